@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// admonitionTitles maps the GitHub alert markers ("> [!NOTE]") to the title
+// shown at the top of the callout. The lowercased marker is used as CSS class.
+var admonitionTitles = map[string]string{
+	"NOTE":      "Note",
+	"TIP":       "Tip",
+	"IMPORTANT": "Important",
+	"WARNING":   "Warning",
+	"CAUTION":   "Caution",
+}
+
+// KindAdmonition is the node kind of an Admonition.
+var KindAdmonition = ast.NewNodeKind("Admonition")
+
+// Admonition is a blockquote that started with a GitHub alert marker.
+type Admonition struct {
+	ast.BaseBlock
+	Marker string // NOTE, TIP, IMPORTANT, WARNING or CAUTION
+}
+
+func (n *Admonition) Kind() ast.NodeKind {
+	return KindAdmonition
+}
+
+func (n *Admonition) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Marker": n.Marker}, nil)
+}
+
+// admonitionTransformer replaces marked blockquotes with Admonition nodes.
+type admonitionTransformer struct{}
+
+func (t *admonitionTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	var quotes []*ast.Blockquote
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if bq, ok := n.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, bq)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	// Rewrite after walking so the tree isn't modified mid-walk
+	for _, bq := range quotes {
+		convertAdmonition(bq, reader.Source())
+	}
+}
+
+// convertAdmonition turns bq into an Admonition if its first line is a marker
+// like [!NOTE]. The marker line itself is removed from the output.
+func convertAdmonition(bq *ast.Blockquote, source []byte) {
+	para, ok := bq.FirstChild().(*ast.Paragraph)
+	if !ok || para.Lines().Len() == 0 {
+		return
+	}
+
+	first := para.Lines().At(0)
+	line := strings.TrimSpace(string(first.Value(source)))
+	if !strings.HasPrefix(line, "[!") || !strings.HasSuffix(line, "]") {
+		return
+	}
+	marker := strings.ToUpper(line[2 : len(line)-1])
+	if _, ok := admonitionTitles[marker]; !ok {
+		return
+	}
+
+	// Drop the inline text making up the marker line
+	for c := para.FirstChild(); c != nil; {
+		next := c.NextSibling()
+		t, ok := c.(*ast.Text)
+		if !ok || t.Segment.Start >= first.Stop {
+			break
+		}
+		para.RemoveChild(para, c)
+		c = next
+	}
+	if para.ChildCount() == 0 {
+		bq.RemoveChild(bq, para)
+	}
+
+	adm := &Admonition{Marker: marker}
+	parent := bq.Parent()
+	parent.ReplaceChild(parent, bq, adm)
+	for c := bq.FirstChild(); c != nil; {
+		next := c.NextSibling()
+		adm.AppendChild(adm, c)
+		c = next
+	}
+}
+
+// admonitionRenderer renders Admonition nodes as styled callout divs.
+type admonitionRenderer struct{}
+
+func (r *admonitionRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindAdmonition, r.renderAdmonition)
+}
+
+func (r *admonitionRenderer) renderAdmonition(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*Admonition)
+	if entering {
+		fmt.Fprintf(w, "<div class=\"admonition %s\">\n<p class=\"admonition-title\">%s</p>\n",
+			strings.ToLower(n.Marker), admonitionTitles[n.Marker])
+	} else {
+		w.WriteString("</div>\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+// admonitions is a goldmark extension rendering GitHub-style alerts
+// (> [!NOTE], > [!TIP], > [!IMPORTANT], > [!WARNING], > [!CAUTION]).
+type admonitions struct{}
+
+// Admonitions renders GitHub-style alert blockquotes as colored callouts.
+var Admonitions = &admonitions{}
+
+func (e *admonitions) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&admonitionTransformer{}, 500),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&admonitionRenderer{}, 500),
+	))
+}
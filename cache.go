@@ -0,0 +1,77 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// defaultCacheSize is the number of rendered documents kept in memory.
+const defaultCacheSize = 128
+
+// renderCache is a size-bounded LRU cache of rendered HTML.
+// Entries are keyed by a hash of the file content, so a changed file
+// simply misses the cache and old entries age out on their own.
+type renderCache struct {
+	mu      sync.Mutex
+	maxSize int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	html string
+}
+
+func newRenderCache(maxSize int) *renderCache {
+	return &renderCache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached HTML for key and marks it as recently used.
+func (c *renderCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry).html, true
+}
+
+// Put stores HTML under key, evicting the least recently used entry when full.
+func (c *renderCache) Put(key, html string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*cacheEntry).html = html
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, html: html})
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cacheKey hashes the file name together with its content. The name is part
+// of the key because it decides how the content is rendered (markdown vs code,
+// and which lexer is used).
+func cacheKey(name string, content []byte) string {
+	h := sha256.New()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+)
+
+// Config file helpers
+//
+// The config files store user preferences as key=value lines:
+//
+//	port=3000
+//	extensions=md,go,js
+//	exclude=node_modules,*.min.js
+//	theme=monokai
+//	lexers=.gohtml:go-html-template,.njk:html,Jenkinsfile:groovy
+//
+// The global file is ~/.livemd.conf (Unix) or %APPDATA%/livemd.conf (Windows).
+// For a named instance (--name), ~/.livemd-NAME.conf overrides it. A
+// .livemd.conf in the current directory overrides both for that project.
+// Command-line flags override all of them.
+//
+// With LIVEMD_HOME set, the global and instance files are livemd.conf and
+// livemd-NAME.conf in that directory instead.
+
+// projectConfigFile is the name of the project-local config file.
+const projectConfigFile = ".livemd.conf"
+
+// Config holds the settings read from the config files.
+type Config struct {
+	Port       int      // default server port
+	Extensions []string // extensions added by "add -r", with leading dot
+	Exclude    []string // name or path patterns skipped by "add -r"
+	Theme      string   // chroma style for code highlighting
+	// Lexers maps extensions (".gohtml") and file names ("jenkinsfile"),
+	// lower-cased, to the Chroma lexer highlighting them
+	Lexers map[string]string
+	// Warnings are the config entries that were ignored as invalid and
+	// should be reported, e.g. unknown lexer names
+	Warnings []string
+}
+
+// defaultConfig returns the built-in settings.
+func defaultConfig() Config {
+	return Config{
+		Port:       3000,
+		Extensions: defaultExtensions,
+		Theme:      defaultStyle,
+	}
+}
+
+// loadConfig returns the built-in settings, overridden by the global config
+// file, then the instance's config file, then the project config file.
+func loadConfig() Config {
+	cfg := defaultConfig()
+	cfg.mergeFile(getConfigFilePath())
+	if instanceName != "" {
+		cfg.mergeFile(getInstanceConfigFilePath())
+	}
+	if abs, err := filepath.Abs(projectConfigFile); err == nil && abs != getConfigFilePath() {
+		cfg.mergeFile(abs)
+	}
+	return cfg
+}
+
+// mergeFile applies the settings found in the config file at path.
+// Missing files, unknown keys and invalid values are ignored.
+func (c *Config) mergeFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "port":
+			if p, err := strconv.Atoi(value); err == nil && p > 0 && p <= 65535 {
+				c.Port = p
+			}
+		case "extensions":
+			if exts := parseExtensions(value); len(exts) > 0 {
+				c.Extensions = exts
+			}
+		case "exclude":
+			c.Exclude = splitList(value)
+		case "theme":
+			if value != "" {
+				c.Theme = value
+			}
+		case "lexers":
+			c.mergeLexers(path, value)
+		}
+	}
+}
+
+// mergeLexers adds the pattern:lexer entries of a lexers= line. A pattern
+// starting with "." or "*." is an extension, anything else a file name.
+// Entries naming a lexer Chroma doesn't have are skipped with a warning.
+func (c *Config) mergeLexers(path, list string) {
+	for _, entry := range splitList(list) {
+		pattern, name, ok := strings.Cut(entry, ":")
+		pattern, name = strings.ToLower(strings.TrimSpace(pattern)), strings.TrimSpace(name)
+		pattern = strings.TrimPrefix(pattern, "*")
+		if !ok || pattern == "" || pattern == "." || name == "" {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("%s: lexers entry %q is not pattern:lexer", path, entry))
+			continue
+		}
+		if lexers.Get(name) == nil {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("%s: unknown lexer %q for %s (see /api/languages)", path, name, pattern))
+			continue
+		}
+		if c.Lexers == nil {
+			c.Lexers = make(map[string]string)
+		}
+		c.Lexers[pattern] = name
+	}
+}
+
+// parseExtensions parses a comma-separated extension list like "md,.go, JS"
+// into lowercase extensions with a leading dot.
+func parseExtensions(list string) []string {
+	var exts []string
+	for _, ext := range splitList(list) {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, strings.ToLower(ext))
+	}
+	return exts
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// livemdHome returns the directory set with LIVEMD_HOME, or "" to use the
+// default locations. It holds the config, lock and state files together,
+// e.g. in CI or containers where the home directory is read-only or shared.
+func livemdHome() string {
+	dir := os.Getenv("LIVEMD_HOME")
+	if dir == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+func getConfigFilePath() string {
+	if home := livemdHome(); home != "" {
+		return filepath.Join(home, "livemd.conf")
+	}
+	if runtime.GOOS == "windows" {
+		appData := os.Getenv("APPDATA")
+		if appData == "" {
+			appData = os.Getenv("USERPROFILE")
+		}
+		return filepath.Join(appData, "livemd.conf")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".livemd.conf")
+}
+
+// getInstanceConfigFilePath returns the config file of the named instance,
+// next to the global one.
+func getInstanceConfigFilePath() string {
+	base := ".livemd"
+	if runtime.GOOS == "windows" || livemdHome() != "" {
+		base = "livemd"
+	}
+	return filepath.Join(filepath.Dir(getConfigFilePath()), instanceFileName(base, ".conf"))
+}
+
+// readConfigPort returns the default port from the config files.
+func readConfigPort() int {
+	return loadConfig().Port
+}
+
+// writeConfigPort sets the port in the global config file, keeping any
+// other settings in it.
+func writeConfigPort(port int) error {
+	path := getConfigFilePath()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var lines []string
+	found := false
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		if key, _, ok := strings.Cut(line, "="); ok && strings.TrimSpace(key) == "port" {
+			line = fmt.Sprintf("port=%d", port)
+			found = true
+		}
+		if line != "" || len(lines) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if !found {
+		lines = append(lines, fmt.Sprintf("port=%d", port))
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
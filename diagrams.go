@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	stdhtml "html"
+	"path/filepath"
+	"strings"
+)
+
+// diagramExtensions maps standalone diagram source files to their diagram
+// language. These render as a diagram in the browser, with a toggle to the
+// highlighted source.
+var diagramExtensions = map[string]string{
+	".mmd":      "mermaid",
+	".mermaid":  "mermaid",
+	".dot":      "dot",
+	".gv":       "dot",
+	".puml":     "plantuml",
+	".plantuml": "plantuml",
+}
+
+// browserDiagrams lists the diagram languages the frontend can draw. Others
+// are shown as highlighted source only.
+var browserDiagrams = map[string]bool{
+	"mermaid": true,
+	"dot":     true,
+}
+
+// diagramKind returns the diagram language of path, or "" if it isn't a
+// diagram source file.
+func diagramKind(path string) string {
+	return diagramExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// renderDiagram renders a diagram source file. The source is embedded for
+// the browser to draw, next to its highlighted form shown by the toggle.
+// PlantUML is drawn by the PlantUML server, if one is configured.
+func (r *Renderer) renderDiagram(path, kind string, content []byte) string {
+	code := string(normalizeNewlines(content))
+	var source bytes.Buffer
+	if err := r.writeCode(&source, path, code, false, 1, nil); err != nil {
+		source.Reset()
+		source.WriteString(r.renderPlainText(code, false))
+	}
+
+	if kind == "plantuml" && r.plantuml != nil {
+		svg, err := r.plantuml.render(code)
+		if err != nil {
+			return fmt.Sprintf(`<div class="diagram-error">PlantUML: %s</div>`, stdhtml.EscapeString(err.Error())) + source.String()
+		}
+		return fmt.Sprintf(`<div class="diagram" data-diagram="%s">
+<div class="preview-bar"><button class="button is-small preview-toggle">Show source</button></div>
+<div class="diagram-view preview-view">%s</div>
+<div class="preview-source is-hidden">%s</div>
+</div>`, kind, svg, source.String())
+	}
+	if !browserDiagrams[kind] {
+		return fmt.Sprintf(`<div class="diagram-note">%s diagram source; start livemd with --plantuml-url to draw it.</div>`, kind) + source.String()
+	}
+	return fmt.Sprintf(`<div class="diagram" data-diagram="%s">
+<div class="preview-bar"><button class="button is-small preview-toggle">Show source</button></div>
+<div class="diagram-view preview-view">Rendering diagram...</div>
+<div class="preview-source is-hidden">%s</div>
+<pre class="diagram-code" hidden>%s</pre>
+</div>`, kind, source.String(), stdhtml.EscapeString(code))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path/filepath"
+	"strings"
+)
+
+// Source formatters
+//
+// A formatter rewrites a code file before it is highlighted, so the browser
+// shows the file as its formatter would leave it. With start --gofmt, .go
+// files are shown gofmt-formatted:
+//
+//	livemd start --gofmt
+//
+// A note above the code says when the file on disk differs. Files the
+// formatter can't parse are shown as they are.
+
+// codeFormatter formats the source of one language.
+type codeFormatter struct {
+	name   string // shown in the note, e.g. "gofmt"
+	format func(src []byte) ([]byte, error)
+}
+
+// codeFormatters returns the formatters enabled by config, by extension.
+func codeFormatters(config RendererConfig) map[string]codeFormatter {
+	formatters := make(map[string]codeFormatter)
+	if config.GoFmt {
+		formatters[".go"] = codeFormatter{name: "gofmt", format: format.Source}
+	}
+	return formatters
+}
+
+// formatter returns the formatter for path, if one is enabled.
+func (r *Renderer) formatter(path string) (codeFormatter, bool) {
+	f, ok := r.formatters[strings.ToLower(filepath.Ext(path))]
+	return f, ok
+}
+
+// formatCode runs path's formatter over content. It returns the content to
+// render and a note to show above it, which is empty when the formatter
+// left the content unchanged. Content the formatter rejects is returned
+// as it is.
+func (r *Renderer) formatCode(path string, content []byte) ([]byte, string) {
+	f, ok := r.formatter(path)
+	if !ok {
+		return content, ""
+	}
+	src := normalizeNewlines(content)
+	formatted, err := f.format(src)
+	if err != nil || bytes.Equal(formatted, src) {
+		return content, ""
+	}
+	return formatted, formatNotice(f.name)
+}
+
+// formatNotice is shown above code that its formatter changed.
+func formatNotice(name string) string {
+	return fmt.Sprintf(`<div class="format-notice" style="padding: 12px; background: #e7f3fe; color: #0c5460; border-radius: 4px; margin-bottom: 16px;">
+			Shown as formatted by %s. The file on disk differs.
+		</div>`, name)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// parseLineRanges parses a line highlight spec such as "10-15,20",
+// "L10-L15" or "2 5-7" into inclusive [start, end] ranges.
+func parseLineRanges(spec string) ([][2]int, error) {
+	var ranges [][2]int
+	fields := strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' })
+	for _, field := range fields {
+		lhs, rhs, isRange := strings.Cut(field, "-")
+		start, err := strconv.Atoi(strings.TrimPrefix(lhs, "L"))
+		if err != nil || start < 1 {
+			return nil, fmt.Errorf("invalid line range: %s", field)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(strings.TrimPrefix(rhs, "L"))
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid line range: %s", field)
+			}
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
+}
+
+// fenceAttributeTransformer accepts pandoc-style attributes on fenced code
+// blocks, e.g. ```{ .go hl_lines="2 5-7" }. goldmark-highlighting reads
+// hl_lines from the node attributes, but only as a list (hl_lines=[2,"5-7"])
+// and only takes the language from the first word of the info string.
+type fenceAttributeTransformer struct{}
+
+func (t *fenceAttributeTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if fcb, ok := n.(*ast.FencedCodeBlock); ok && entering && fcb.Info != nil {
+			applyFenceAttributes(fcb, source)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// applyFenceAttributes parses the {...} part of fcb's info string into node
+// attributes, converting a string hl_lines into the list form. When the info
+// string starts with the attributes, the first .class is used as language.
+func applyFenceAttributes(fcb *ast.FencedCodeBlock, source []byte) {
+	seg := fcb.Info.Segment
+	info := seg.Value(source)
+	open := bytes.IndexByte(info, '{')
+	if open < 0 {
+		return
+	}
+	attrs, ok := parser.ParseAttributes(text.NewReader(info[open:]))
+	if !ok {
+		return
+	}
+
+	for _, attr := range attrs {
+		value := attr.Value
+		if string(attr.Name) == "hl_lines" {
+			if spec, ok := value.([]byte); ok {
+				value = hlLinesValue(string(spec))
+			}
+		}
+		fcb.SetAttribute(attr.Name, value)
+	}
+
+	// { .go ... }: point the info segment at "go" so it is used as language
+	if len(bytes.TrimSpace(info[:open])) == 0 {
+		if class, ok := fcb.AttributeString("class"); ok {
+			lang := bytes.Fields(class.([]byte))
+			if len(lang) > 0 {
+				if i := bytes.Index(info[open:], lang[0]); i >= 0 {
+					start := seg.Start + open + i
+					fcb.Info = ast.NewTextSegment(text.NewSegment(start, start+len(lang[0])))
+				}
+			}
+		}
+	}
+}
+
+// hlLinesValue converts "2 5-7" into the form goldmark-highlighting expects
+// for hl_lines: numbers for single lines and "a-b" strings for ranges.
+func hlLinesValue(spec string) []interface{} {
+	ranges, err := parseLineRanges(spec)
+	if err != nil {
+		return nil
+	}
+	lines := make([]interface{}, 0, len(ranges))
+	for _, r := range ranges {
+		if r[0] == r[1] {
+			lines = append(lines, float64(r[0]))
+		} else {
+			lines = append(lines, []byte(fmt.Sprintf("%d-%d", r[0], r[1])))
+		}
+	}
+	return lines
+}
+
+// fenceAttributes is a goldmark extension enabling pandoc-style fenced code
+// attributes, including line highlighting with hl_lines.
+type fenceAttributes struct{}
+
+// FenceAttributes parses {.lang hl_lines="2 5-7"} on fenced code blocks.
+var FenceAttributes = &fenceAttributes{}
+
+func (e *fenceAttributes) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&fenceAttributeTransformer{}, 500),
+	))
+}
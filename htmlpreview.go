@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	stdhtml "html"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// isHTML reports whether path is an HTML page.
+func isHTML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".html" || ext == ".htm"
+}
+
+// htmlPreview reports whether path is shown as a rendered page. This needs
+// start --html-preview, and only applies to local files, which the page is
+// loaded from through /api/content.
+func (r *Renderer) htmlPreview(path string) bool {
+	return r.config.HTMLPreview && isHTML(path) && filepath.IsAbs(path)
+}
+
+// renderHTMLPreview renders an HTML file as the page itself, in a sandboxed
+// iframe, next to its highlighted source shown by the toggle.
+func (r *Renderer) renderHTMLPreview(path string, content []byte) string {
+	code := string(normalizeNewlines(content))
+	var source bytes.Buffer
+	if err := r.writeCode(&source, path, code, false, 1, nil); err != nil {
+		source.Reset()
+		source.WriteString(r.renderPlainText(code, false))
+	}
+
+	// The hash makes the iframe reload when the file changes
+	src := "/api/content?path=" + url.QueryEscape(path) + "&v=" + contentHash(content)[:12]
+	return fmt.Sprintf(`<div class="html-preview">
+<div class="preview-bar"><button class="button is-small preview-toggle">Show source</button></div>
+<iframe class="html-preview-frame preview-view" sandbox="allow-scripts" src="%s"></iframe>
+<div class="preview-source is-hidden">%s</div>
+</div>`, stdhtml.EscapeString(src), source.String())
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Includes
+//
+// With start --includes, a markdown line holding only an include directive
+// is replaced by the named file, resolved relative to the including file:
+//
+//	{{include chapters/intro.md}}
+//
+// Included files may include others. A cycle, a missing file or nesting
+// deeper than maxIncludeDepth is shown as a caution note in place of the
+// directive, as is a file outside the --root directories. Directives inside
+// fenced code blocks are left alone.
+
+// maxIncludeDepth bounds how deeply includes may nest.
+const maxIncludeDepth = 16
+
+// includePattern matches a line holding only an include directive.
+var includePattern = regexp.MustCompile(`^\s*\{\{\s*include\s+(.+?)\s*\}\}\s*$`)
+
+// expandIncludes replaces the include directives in the markdown at path.
+// It also returns every file that was included, directly or not.
+// Files outside roots aren't included, unless roots is empty.
+func expandIncludes(path string, content []byte, roots []string) ([]byte, []string) {
+	var included []string
+	expanded := expandIncludesFrom(path, content, []string{path}, roots, &included)
+	return expanded, included
+}
+
+// expandIncludesFrom expands the includes of content, read from path. stack
+// holds the chain of files being expanded, for detecting cycles.
+func expandIncludesFrom(path string, content []byte, stack, roots []string, included *[]string) []byte {
+	if !bytes.Contains(content, []byte("{{")) {
+		return content
+	}
+
+	var out bytes.Buffer
+	fence := ""
+	for _, line := range strings.SplitAfter(string(normalizeNewlines(content)), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			out.WriteString(line)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			out.WriteString(line)
+			continue
+		}
+
+		m := includePattern.FindStringSubmatch(strings.TrimSuffix(line, "\n"))
+		if m == nil {
+			out.WriteString(line)
+			continue
+		}
+		name := strings.Trim(m[1], `"'`)
+		target := name
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		target = filepath.Clean(target)
+
+		out.Write(includeFile(name, target, stack, roots, included))
+		out.WriteString("\n")
+	}
+	return out.Bytes()
+}
+
+// includeFile returns the expanded content of target, or a note saying why
+// it couldn't be included.
+func includeFile(name, target string, stack, roots []string, included *[]string) []byte {
+	for _, p := range stack {
+		if p == target {
+			chain := make([]string, 0, len(stack)+1)
+			for _, s := range append(stack, target) {
+				chain = append(chain, filepath.Base(s))
+			}
+			return includeNote(fmt.Sprintf("Include cycle stopped: %s", strings.Join(chain, " → ")))
+		}
+	}
+	if len(stack) > maxIncludeDepth {
+		return includeNote(fmt.Sprintf("Not included %s: includes nest deeper than %d levels", name, maxIncludeDepth))
+	}
+
+	if len(roots) > 0 && !withinRoots(roots, NormalizePath(target)) {
+		return includeNote(fmt.Sprintf("Not included %s: outside the allowed roots", name))
+	}
+
+	content, err := os.ReadFile(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return includeNote(fmt.Sprintf("Not included %s: file not found", name))
+		}
+		return includeNote(fmt.Sprintf("Not included %s: %v", name, err))
+	}
+	*included = append(*included, target)
+	return bytes.TrimRight(expandIncludesFrom(target, content, append(stack, target), roots, included), "\n")
+}
+
+// includeNote renders a failed include as a caution callout.
+func includeNote(msg string) []byte {
+	return []byte("\n> [!CAUTION]\n> " + msg + "\n")
+}
+
+// includeMarkdown expands the includes of markdown at a local path when
+// includes are enabled, and remembers which files it included.
+func (r *Renderer) includeMarkdown(path string, content []byte) []byte {
+	if !r.config.Includes || !filepath.IsAbs(path) {
+		return content
+	}
+	expanded, included := expandIncludes(path, content, r.roots)
+
+	r.includes.mu.Lock()
+	defer r.includes.mu.Unlock()
+	if len(included) == 0 {
+		delete(r.includes.byFile, path)
+	} else {
+		r.includes.byFile[path] = included
+	}
+	return expanded
+}
+
+// includeIndex records what each markdown file's last render included.
+type includeIndex struct {
+	mu     sync.Mutex
+	byFile map[string][]string
+}
+
+// Includes returns the files the last render of path included.
+func (r *Renderer) Includes(path string) []string {
+	r.includes.mu.Lock()
+	defer r.includes.mu.Unlock()
+	return r.includes.byFile[path]
+}
+
+// ForgetIncludes drops what path's renders included, once it is no longer
+// watched.
+func (r *Renderer) ForgetIncludes(path string) {
+	r.includes.mu.Lock()
+	defer r.includes.mu.Unlock()
+	delete(r.includes.byFile, path)
+}
+
+// Includers returns the files whose last render included path.
+func (r *Renderer) Includers(path string) []string {
+	r.includes.mu.Lock()
+	defer r.includes.mu.Unlock()
+	var parents []string
+	for parent, included := range r.includes.byFile {
+		for _, p := range included {
+			if p == path {
+				parents = append(parents, parent)
+				break
+			}
+		}
+	}
+	return parents
+}
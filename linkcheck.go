@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// Link checking
+//
+// GET /api/linkcheck?path=FILE and "livemd check FILE" report the links and
+// images of a markdown file whose local targets don't exist, with the line
+// they are on. Targets are resolved relative to the file; a target starting
+// with / is resolved from the --root directory holding the file, and isn't
+// checked without one. A #fragment must name a heading (or an HTML id) of
+// the markdown file it points into.
+//
+// With recursive, the markdown files it links to are checked as well, and
+// the ones they link to, up to maxLinkCheckFiles. With external, http(s)
+// links are probed with HEAD requests; other schemes are never checked.
+// Links written as raw HTML aren't checked.
+
+// maxLinkCheckFiles bounds how many files a recursive check reads.
+const maxLinkCheckFiles = 500
+
+// linkCheckWorkers is how many external links are probed at once.
+const linkCheckWorkers = 8
+
+// linkCheckClient probes external links. Redirects are followed.
+var linkCheckClient = &http.Client{Timeout: 10 * time.Second}
+
+// linkParser parses markdown the way the renderer does, as far as links and
+// heading IDs go.
+var linkParser = goldmark.New(
+	goldmark.WithExtensions(extension.GFM, extension.Footnote, extension.DefinitionList),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+).Parser()
+
+// htmlIDPattern matches the id and name attributes of raw HTML, which are
+// link fragments as well as headings.
+var htmlIDPattern = regexp.MustCompile(`\b(?:id|name)\s*=\s*["']([^"']+)["']`)
+
+// LinkCheckResult lists the files checked and the broken links found in
+// them, in file and line order.
+type LinkCheckResult struct {
+	Files  []string     `json:"files"`
+	Broken []BrokenLink `json:"broken"`
+}
+
+// BrokenLink is a link or image whose target is missing.
+type BrokenLink struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Target string `json:"target"`
+	Image  bool   `json:"image,omitempty"`
+	Error  string `json:"error"`
+}
+
+// mdLink is a link or image destination and the line it is on.
+type mdLink struct {
+	line   int
+	target string
+	image  bool
+}
+
+// linkChecker holds the state of one check.
+type linkChecker struct {
+	roots     []string
+	recursive bool
+	external  bool
+
+	queue   []string
+	queued  map[string]bool
+	anchors map[string]map[string]bool
+	probes  []BrokenLink // external links to probe, Error unset
+	result  LinkCheckResult
+}
+
+// checkLinks checks the links of the markdown file at path. Files outside
+// roots are neither checked nor followed, unless roots is empty. It fails
+// only when path itself can't be read.
+func checkLinks(path string, roots []string, recursive, external bool) (LinkCheckResult, error) {
+	c := &linkChecker{
+		roots:     roots,
+		recursive: recursive,
+		external:  external,
+		queue:     []string{path},
+		queued:    map[string]bool{path: true},
+		anchors:   make(map[string]map[string]bool),
+		result:    LinkCheckResult{Files: []string{}, Broken: []BrokenLink{}},
+	}
+	for i := 0; i < len(c.queue); i++ {
+		file := c.queue[i]
+		links, err := c.parse(file)
+		if err != nil {
+			if i == 0 {
+				return LinkCheckResult{}, err
+			}
+			continue
+		}
+		c.result.Files = append(c.result.Files, file)
+		for _, l := range links {
+			if msg := c.check(file, l); msg != "" {
+				c.result.Broken = append(c.result.Broken, BrokenLink{
+					File: file, Line: l.line, Target: l.target, Image: l.image, Error: msg,
+				})
+			}
+		}
+	}
+	c.probeExternal()
+	return c.result, nil
+}
+
+// parse reads the markdown file at path and returns its links, recording
+// the anchors it defines.
+func (c *linkChecker) parse(path string) ([]mdLink, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	src := normalizeNewlines(content)
+	if _, body, ok := splitFrontMatter(src); ok {
+		// Blanked rather than cut, so line numbers stay right
+		src = append(bytes.Repeat([]byte("\n"), bytes.Count(src[:len(src)-len(body)], []byte("\n"))), body...)
+	}
+
+	anchors := make(map[string]bool)
+	for _, m := range htmlIDPattern.FindAllSubmatch(src, -1) {
+		anchors[string(m[1])] = true
+	}
+	var links []mdLink
+	doc := linkParser.Parse(text.NewReader(src))
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			if id, ok := n.AttributeString("id"); ok {
+				if b, ok := id.([]byte); ok {
+					anchors[string(b)] = true
+				}
+			}
+		case *ast.Link:
+			links = append(links, mdLink{line: nodeLine(n, src), target: string(n.Destination)})
+		case *ast.Image:
+			links = append(links, mdLink{line: nodeLine(n, src), target: string(n.Destination), image: true})
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL {
+				links = append(links, mdLink{line: nodeLine(n, src), target: string(n.URL(src))})
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	c.anchors[path] = anchors
+	return links, nil
+}
+
+// nodeLine returns the line of src an inline node is on: that of its first
+// text, or of the block holding it when it has none.
+func nodeLine(n ast.Node, src []byte) int {
+	offset := -1
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := c.(*ast.Text); ok && entering {
+			offset = t.Segment.Start
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	for p := n; offset < 0 && p != nil; p = p.Parent() {
+		if p.Type() == ast.TypeBlock && p.Lines().Len() > 0 {
+			offset = p.Lines().At(0).Start
+		}
+	}
+	if offset < 0 {
+		return 0
+	}
+	return bytes.Count(src[:offset], []byte("\n")) + 1
+}
+
+// check returns why the link l of file is broken, or "" when it isn't or
+// can't be checked here. Linked markdown files are queued when recursive.
+func (c *linkChecker) check(file string, l mdLink) string {
+	if l.target == "" {
+		return ""
+	}
+	u, err := url.Parse(l.target)
+	if err != nil {
+		return "malformed link"
+	}
+	if u.Scheme != "" || u.Host != "" {
+		if c.external && (u.Scheme == "http" || u.Scheme == "https") {
+			c.probes = append(c.probes, BrokenLink{File: file, Line: l.line, Target: l.target, Image: l.image})
+		}
+		return ""
+	}
+	if u.Path == "" {
+		if u.Fragment != "" && !c.hasAnchor(file, u.Fragment) {
+			return "no heading #" + u.Fragment
+		}
+		return ""
+	}
+
+	target := filepath.FromSlash(u.Path)
+	if strings.HasPrefix(u.Path, "/") {
+		root := c.rootOf(file)
+		if root == "" {
+			// Without roots there's nothing to resolve it from
+			return ""
+		}
+		target = filepath.Join(root, target)
+	} else {
+		target = filepath.Join(filepath.Dir(file), target)
+	}
+	if !withinRoots(c.roots, NormalizePath(target)) {
+		return ""
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "not found"
+		}
+		var perr *fs.PathError
+		if errors.As(err, &perr) {
+			err = perr.Err
+		}
+		return err.Error()
+	}
+	if info.IsDir() || !isMarkdown(target) {
+		return ""
+	}
+	if u.Fragment != "" && !c.hasAnchor(target, u.Fragment) {
+		return fmt.Sprintf("no heading #%s in %s", u.Fragment, filepath.Base(target))
+	}
+	if c.recursive && !c.queued[target] && len(c.queue) < maxLinkCheckFiles {
+		c.queued[target] = true
+		c.queue = append(c.queue, target)
+	}
+	return ""
+}
+
+// hasAnchor reports whether the markdown file at path defines anchor,
+// reading it if it hasn't been yet. An unreadable file has no anchors.
+func (c *linkChecker) hasAnchor(path, anchor string) bool {
+	if _, ok := c.anchors[path]; !ok {
+		if _, err := c.parse(path); err != nil {
+			c.anchors[path] = map[string]bool{}
+		}
+	}
+	return c.anchors[path][anchor]
+}
+
+// rootOf returns the root directory holding path, or "" when there are no
+// roots.
+func (c *linkChecker) rootOf(path string) string {
+	normalized := NormalizePath(path)
+	for _, root := range c.roots {
+		if IsWithin(root, normalized) {
+			return root
+		}
+	}
+	return ""
+}
+
+// probeExternal probes the external links found, each URL once, and adds
+// the ones that fail to the broken links.
+func (c *linkChecker) probeExternal() {
+	if len(c.probes) == 0 {
+		return
+	}
+	results := make(map[string]string)
+	for _, p := range c.probes {
+		results[p.Target] = ""
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, linkCheckWorkers)
+	for target := range results {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			msg := ""
+			if err := probeURL(target); err != nil {
+				msg = err.Error()
+			}
+			mu.Lock()
+			results[target] = msg
+			mu.Unlock()
+		}(target)
+	}
+	wg.Wait()
+
+	for _, p := range c.probes {
+		if msg := results[p.Target]; msg != "" {
+			p.Error = msg
+			c.result.Broken = append(c.result.Broken, p)
+		}
+	}
+	sortBrokenLinks(c.result.Broken, c.result.Files)
+}
+
+// probeURL sends a HEAD request for rawURL, falling back to GET for servers
+// that don't answer HEAD.
+func probeURL(rawURL string) error {
+	resp, err := linkCheckClient.Head(rawURL)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp.Body.Close()
+		resp, err = linkCheckClient.Get(rawURL)
+	}
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// sortBrokenLinks orders broken links by the order their files were
+// checked in, then by line.
+func sortBrokenLinks(broken []BrokenLink, files []string) {
+	order := make(map[string]int, len(files))
+	for i, f := range files {
+		order[f] = i
+	}
+	sort.SliceStable(broken, func(i, j int) bool {
+		if order[broken[i].File] != order[broken[j].File] {
+			return order[broken[i].File] < order[broken[j].File]
+		}
+		return broken[i].Line < broken[j].Line
+	})
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,12 +16,31 @@ type LogEntry struct {
 	Message string    `json:"message"`
 }
 
+// logLevels ranks the log levels from least to most severe.
+var logLevels = map[string]int{
+	"info":  0,
+	"warn":  1,
+	"error": 2,
+}
+
+// checkLogLevel validates a --log-level value.
+func checkLogLevel(level string) error {
+	if _, ok := logLevels[level]; !ok {
+		return fmt.Errorf("unknown log level %q (use info, warn or error)", level)
+	}
+	return nil
+}
+
 // Logger stores log entries and broadcasts to clients
 type Logger struct {
-	mu      sync.RWMutex
-	entries []LogEntry
-	maxSize int
-	hub     *Hub
+	mu        sync.RWMutex
+	entries   []LogEntry
+	maxSize   int
+	minLevel  int // entries below this rank are dropped
+	hub       *Hub
+	jsonOut   *json.Encoder // writes each entry as a JSON line, if set
+	textOut   io.Writer     // writes entries of textLevel and above as text, if set
+	textLevel int
 }
 
 func NewLogger(maxSize int) *Logger {
@@ -31,8 +54,42 @@ func (l *Logger) SetHub(hub *Hub) {
 	l.hub = hub
 }
 
+// SetJSONOutput makes the logger also write every entry to w as a line of
+// JSON ({"time":...,"level":...,"message":...}), for log aggregators.
+func (l *Logger) SetJSONOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.jsonOut = json.NewEncoder(w)
+}
+
+// SetTextOutput makes the logger also write the entries at least as severe
+// as level to w, one line each ("2006-01-02 15:04:05 WARN message"), for
+// the console and journald.
+func (l *Logger) SetTextOutput(w io.Writer, level string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.textOut = w
+	l.textLevel = logLevels[level]
+}
+
+// SetLevel drops entries less severe than level ("info", "warn" or "error").
+// Unknown levels are ignored.
+func (l *Logger) SetLevel(level string) {
+	rank, ok := logLevels[level]
+	if !ok {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.minLevel = rank
+}
+
 func (l *Logger) add(level, message string) {
 	l.mu.Lock()
+	if logLevels[level] < l.minLevel {
+		l.mu.Unlock()
+		return
+	}
 	entry := LogEntry{
 		Time:    time.Now(),
 		Level:   level,
@@ -42,6 +99,12 @@ func (l *Logger) add(level, message string) {
 	if len(l.entries) > l.maxSize {
 		l.entries = l.entries[1:]
 	}
+	if l.jsonOut != nil {
+		l.jsonOut.Encode(entry)
+	}
+	if l.textOut != nil && logLevels[level] >= l.textLevel {
+		fmt.Fprintf(l.textOut, "%s %-5s %s\n", entry.Time.Format("2006-01-02 15:04:05"), strings.ToUpper(level), message)
+	}
 	l.mu.Unlock()
 
 	// Broadcast to clients
@@ -70,3 +133,16 @@ func (l *Logger) GetEntries() []LogEntry {
 	copy(entries, l.entries)
 	return entries
 }
+
+// logWriter passes what is written through the standard log package (by
+// the watchers and net/http) to a Logger as warnings, one per line.
+type logWriter struct {
+	logger *Logger
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.logger.Warn(line)
+	}
+	return len(p), nil
+}
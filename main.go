@@ -9,7 +9,8 @@
 // The application follows a client-server model:
 //   - Server process: Started with 'livemd start', runs in foreground serving HTTP/WebSocket
 //   - CLI commands: Communicate with server via HTTP API (add, remove, list, stop)
-//   - Lock file: Stores server port for CLI-server communication (~/.livemd.lock)
+//   - Lock file: Stores server port for CLI-server communication (/tmp/livemd.lock,
+//     or /tmp/livemd-NAME.lock for an instance started with --name)
 //
 // # Commands
 //
@@ -28,6 +29,7 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"flag"
@@ -35,18 +37,21 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // defaultExtensions defines the file types watched when recursively adding directories.
 // These extensions cover common documentation, code, and configuration files that
 // developers typically want to preview or monitor during development.
 var defaultExtensions = []string{
-	".md", ".markdown",
+	".md", ".markdown", ".rst",
 	".go",
 	".cs", ".razor",
 	".js", ".ts", ".jsx", ".tsx",
@@ -72,18 +77,83 @@ Usage:
   livemd start [--port PORT]    Start the server
   livemd add <file.md>          Add file to watch
   livemd add <folder> -r        Add folder recursively
+  livemd add <https://...>      Add a remote file (polled for changes)
+  livemd add -                  Add paths read from stdin (one per line)
+  livemd add <folder> -r -q     Print only the summary, not each file
+  livemd add <folder> -r -y     Don't ask before adding more than 500 files
+  livemd add <file.md> --active Watch for changes right away, without choosing Watch in a browser
+  cmd | livemd preview          Show content piped from stdin (--as NAME, default stdin.md;
+                                piping again under the same name updates it)
   livemd remove <file.md>       Remove file from watch
   livemd list                   List watched files
+  livemd export-session         Print a script of add commands recreating the watch list
   livemd stop                   Stop the server
+  livemd wait                   Wait until the server is up (--timeout, default 10s)
+  livemd check <file.md>...     Report broken links and images (-r to follow
+                                linked markdown, --external to probe web links)
   livemd port                   Show current port
   livemd port <number>          Set default port
   livemd version                Print version
   livemd update                 Update to latest release
+  livemd update v1.2.3          Install a specific release (--force to downgrade)
 
 Options:
   --port PORT    Port to serve on (default 3000)
+  --no-auto-port Fail if the port is in use, instead of using the next free one
+  --bind ADDR    Address to listen on, e.g. 127.0.0.1 (default all interfaces)
+  --max-lines N  Lines of a code file to render (default 1000, 0 = no limit)
+  --collapse-over N  Fold the lines of a code file past N behind "Show more"
+  --max-file-size SIZE  Largest file to render (default 10MB, 0 = no limit)
+  --theme NAME   Code highlighting style (default github)
+  --exts EXT     Extensions for "add -r" without --filter (e.g. "md,go,rs")
+  --log-json     Write log entries to stdout as JSON lines
+  --log-level L  Least severe log entries to keep: info (default), warn, error
+  --log-stderr   Write warnings and errors to stderr (stdout keeps the banner)
+  --verbose      Write every log entry to stderr, e.g. connections and changes
+  --allow-open   Let the browser open files in $EDITOR or the file manager
+  --allow-origin ORIGIN  Browser origin allowed besides localhost (repeatable;
+                         default this machine's LAN addresses)
+  --root DIR     Only watch files under DIR (repeatable; default any file)
+  --css FILE     Stylesheet applied after the built-in styles (read at start)
+  --plantuml-url URL  PlantUML server drawing .puml files and plantuml fences
+  --with-source  Show markdown source next to the rendered output
+  --partial-updates  Send only the changed blocks of large documents
+  --html-preview Show .html files as the rendered page (trusted files only)
+  --gofmt        Show .go files gofmt-formatted, noting drift from the file on disk
+  --includes     Expand {{include file.md}} lines in markdown
+  --structured   Show JSON, YAML and TOML files as a foldable tree
+  --check-updates=false  Don't look for a newer release at startup
+  --single-watcher  Watch files through one watcher on their directories
+                    (for hundreds of files; avoids "too many open files")
+  --refresh-interval D  Also re-check active files for changes every D, e.g. 5s
+                        (for mounts that miss file events; default off)
+  --hard-wraps=false  Keep soft line breaks in paragraphs (start only)
+  --unsafe=false      Strip raw HTML from markdown (start only)
+  --no-highlight      Show code files as plain text (start only)
+  --highlight-max-size SIZE  Largest code to highlight (default 1MB, 0 = no limit)
+  --render-timeout D  Longest a file may take to render (default 10s, 0 = no limit)
   -r, --recursive   Recursively add files from folder
-  --filter EXT      Filter by extensions (comma-separated, e.g. "md,go,js")
+  --filter EXT      Filter by extensions (comma-separated, e.g. "md,go,js");
+                    "!" omits, from the default set if nothing else is listed
+                    (e.g. "!lock,!min.js")
+  --exclude PAT     Skip matching names or paths (comma-separated, e.g. "node_modules,*.min.js")
+  --dry-run         Show what add would watch without adding anything
+  --max-files N     Ask before adding more files than this (default 500, 0 = no limit)
+  -y, --yes         Add large folders, or update across a major version,
+                    without asking (needed without a terminal)
+  --force           Let update install an older or the current release
+  --host HOST       Server host for add/remove/list/stop (default localhost,
+                    env LIVEMD_HOST; a remote host also needs --port)
+  --name NAME       Server instance for start/add/remove/list/stop, to run
+                    several servers side by side (env LIVEMD_NAME)
+
+Environment:
+  LIVEMD_HOME       Directory for the config, lock and state files
+                    (default ~, /tmp for the lock, %%APPDATA%% on Windows)
+  GITHUB_TOKEN      Sent to GitHub by update and the update check, to raise
+                    the API rate limit
+  HTTPS_PROXY       Proxy for update and the update check (also HTTP_PROXY,
+                    NO_PROXY)
 
 Examples:
   livemd start
@@ -91,7 +161,15 @@ Examples:
   livemd add docs/guide.md
   livemd add ./docs -r
   livemd add ./src -r --filter "md,go"
+  livemd add ./src -r --filter "md,go" --dry-run
+  git diff --name-only | livemd add -
+  ./gen-report.sh | livemd preview --as report.md
   livemd list
+  livemd export-session > session.sh
+  livemd check README.md docs/*.md -r
+  livemd list --host 192.168.1.20 --port 3000
+  livemd start --name docs --port 3001
+  livemd add README.md --name docs
 `, Version)
 	}
 
@@ -99,6 +177,16 @@ Examples:
 		flag.Usage()
 		os.Exit(1)
 	}
+	if err := setInstanceName(instanceName); err != nil {
+		fmt.Fprintf(os.Stderr, "LIVEMD_NAME: %v\n", err)
+		os.Exit(1)
+	}
+	if home := livemdHome(); home != "" {
+		if err := os.MkdirAll(home, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "LIVEMD_HOME: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
 	cmd := os.Args[1]
 
@@ -111,12 +199,20 @@ Examples:
 		cmdRemove()
 	case "list":
 		cmdList()
+	case "export-session":
+		cmdExportSession()
 	case "stop":
 		cmdStop()
+	case "wait":
+		cmdWait()
+	case "check":
+		cmdCheck()
+	case "preview":
+		cmdPreview()
 	case "port":
 		cmdPort()
 	case "version", "--version", "-v":
-		fmt.Printf("livemd %s %s/%s\n", Version, runtime.GOOS, runtime.GOARCH)
+		cmdVersion()
 	case "update":
 		cmdUpdate()
 	case "--help", "-h", "help":
@@ -133,45 +229,162 @@ Examples:
 // If the server is already running (detected via lock file), it exits with an error.
 // The server runs in the foreground until stopped via "livemd stop" or SIGINT.
 func cmdStart() {
-	defaultPort := readConfigPort()
+	// --name picks the instance's config file, which supplies flag defaults
+	if err := preparseName(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfg := loadConfig()
 	fs := flag.NewFlagSet("start", flag.ExitOnError)
-	port := fs.Int("port", defaultPort, "port to serve on")
+	addNameFlag(fs)
+	port := fs.Int("port", cfg.Port, "port to serve on")
+	noAutoPort := fs.Bool("no-auto-port", false, "fail if the port is in use instead of using the next free one (for fixed reverse-proxy setups)")
+	bind := fs.String("bind", "", "address to listen on, e.g. 127.0.0.1 (default all interfaces)")
+	maxLines := fs.Int("max-lines", defaultMaxLines, "lines of a code file to render (0 for no limit)")
+	collapseOver := fs.Int("collapse-over", 0, "fold the lines of a code file past this many into a \"Show N more lines\" block (0 to show all)")
+	maxFileSize := fs.String("max-file-size", "10MB", "largest file to render, e.g. 500KB or 50MB (0 for no limit)")
+	exts := fs.String("exts", "", "extensions picked up by \"add -r\" without --filter (comma-separated, e.g. \"md,go,rs\")")
+	logJSON := fs.Bool("log-json", false, "write log entries to stdout as JSON lines")
+	logStderr := fs.Bool("log-stderr", false, "write warnings and errors to stderr as text lines (e.g. for journald)")
+	verbose := fs.Bool("verbose", false, "write every log entry to stderr, including connections and file changes")
+	renderTimeout := fs.Duration("render-timeout", defaultRenderTimeout, "longest a file may take to render before a placeholder is shown (0 for no limit)")
+	customCSS := fs.String("css", "", "stylesheet to apply after the built-in styles (read at startup)")
+	plantUMLURL := fs.String("plantuml-url", "", "PlantUML server for .puml files and plantuml fences, e.g. http://localhost:8080")
+	partialUpdates := fs.Bool("partial-updates", false, "send only the changed top-level blocks when a large document changes, instead of all of its HTML")
+	withSource := fs.Bool("with-source", false, "send the raw markdown to browsers to show next to the rendered output")
+	structured := fs.Bool("structured", false, "show JSON, YAML and TOML files as a foldable tree, with a toggle to the source")
+	includes := fs.Bool("includes", false, "expand {{include file}} lines in markdown, relative to the including file")
+	goFmt := fs.Bool("gofmt", false, "show .go files gofmt-formatted, noting when the file on disk differs")
+	htmlPreview := fs.Bool("html-preview", false, "show .html files as the rendered page (runs their scripts; trusted files only)")
+	checkUpdates := fs.Bool("check-updates", true, "look for a newer release in the background at startup")
+	singleWatcher := fs.Bool("single-watcher", false, "watch files through one watcher on their directories, for large trees")
+	refreshInterval := fs.Duration("refresh-interval", 0, "also re-check active files for changes this often, for filesystems that miss events (0 to disable)")
+	var allowOrigins listFlag
+	fs.Var(&allowOrigins, "allow-origin", "browser origin allowed to use the server besides localhost, e.g. http://docs.example.com (repeatable; default the LAN addresses)")
+	var roots listFlag
+	fs.Var(&roots, "root", "only let files under this directory be watched, rendered or included (repeatable; default any file)")
+	allowOpen := fs.Bool("allow-open", false, "let the browser open watched files in $EDITOR or the file manager on this machine")
+	logLevel := fs.String("log-level", "info", "least severe log entries to keep: info, warn or error")
+	theme := fs.String("theme", cfg.Theme, "chroma style for code highlighting (e.g. github, monokai)")
+	hardWraps := fs.Bool("hard-wraps", true, "render newlines inside paragraphs as line breaks")
+	unsafe := fs.Bool("unsafe", true, "render raw HTML embedded in markdown")
+	noHighlight := fs.Bool("no-highlight", false, "show code files as plain text, without syntax highlighting")
+	highlightMaxSize := fs.String("highlight-max-size", "1MB", "largest code to highlight; larger code is shown as plain text (0 for no limit)")
 	fs.Parse(os.Args[2:])
 
+	maxFileBytes, err := parseSize(*maxFileSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --max-file-size: %v\n", err)
+		os.Exit(1)
+	}
+	highlightMaxBytes, err := parseSize(*highlightMaxSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --highlight-max-size: %v\n", err)
+		os.Exit(1)
+	}
+	var css []byte
+	if *customCSS != "" {
+		css, err = os.ReadFile(CleanPath(*customCSS))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading --css: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for i, root := range roots {
+		info, err := os.Stat(CleanPath(root))
+		if err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "Invalid --root: %s is not a directory\n", root)
+			os.Exit(1)
+		}
+		roots[i] = CleanPath(root)
+	}
+	if err := checkLogLevel(*logLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --log-level: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Check if already running
 	if lockPort, err := readLockFile(); err == nil {
-		fmt.Printf("LiveMD already running on port %d\n", lockPort)
+		if instanceName != "" {
+			fmt.Printf("LiveMD %q already running on port %d\n", instanceName, lockPort)
+		} else {
+			fmt.Printf("LiveMD already running on port %d\n", lockPort)
+		}
 		printServerAddresses(lockPort)
 		os.Exit(1)
 	}
 
 	// Auto-detect available port if the requested one is in use
 	actualPort := *port
-	if !isPortAvailable(actualPort) {
+	if *noAutoPort && !isPortAvailable(*bind, actualPort) {
+		fmt.Fprintf(os.Stderr, "Port %d is in use (--no-auto-port keeps livemd from using another one)\n", actualPort)
+		os.Exit(1)
+	}
+	if !isPortAvailable(*bind, actualPort) {
 		originalPort := actualPort
-		actualPort = findAvailablePort(actualPort)
+		actualPort = findAvailablePort(*bind, actualPort)
 		fmt.Printf("  Port %d is in use, using port %d instead\n", originalPort, actualPort)
 	}
 
-	// Write lock file
-	if err := writeLockFile(actualPort); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing lock file: %v\n", err)
-		os.Exit(1)
+	renderConfig := DefaultRendererConfig()
+	renderConfig.MaxLines = *maxLines
+	renderConfig.CollapseOver = *collapseOver
+	renderConfig.MaxFileSize = maxFileBytes
+	renderConfig.Style = *theme
+	renderConfig.Lexers = cfg.Lexers
+	for _, warning := range cfg.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
 	}
-
-	// Start server
-	fmt.Printf("\n  LiveMD server started\n")
-	printServerAddresses(actualPort)
-	fmt.Println("  Use 'livemd add <file.md>' to watch files")
-	fmt.Println("  Use 'livemd stop' to stop the server")
-	fmt.Println()
-
-	StartServer(actualPort)
+	renderConfig.HardWraps = *hardWraps
+	renderConfig.Unsafe = *unsafe
+	renderConfig.NoHighlight = *noHighlight
+	renderConfig.HighlightMaxSize = highlightMaxBytes
+	renderConfig.RenderTimeout = *renderTimeout
+	renderConfig.HTMLPreview = *htmlPreview
+	renderConfig.GoFmt = *goFmt
+	renderConfig.Includes = *includes
+	renderConfig.Structured = *structured
+	renderConfig.PlantUMLURL = *plantUMLURL
+
+	StartServer(ServerConfig{
+		Port:           actualPort,
+		Bind:           *bind,
+		Renderer:       renderConfig,
+		Extensions:     parseExtensions(*exts),
+		LogJSON:        *logJSON,
+		LogLevel:       *logLevel,
+		LogStderr:      *logStderr,
+		Verbose:        *verbose,
+		AllowOpen:      *allowOpen,
+		CustomCSS:      string(css),
+		SingleWatcher:  *singleWatcher,
+		CheckUpdates:   *checkUpdates,
+		WithSource:     *withSource,
+		PartialUpdates: *partialUpdates,
+		AllowOrigins:   allowOrigins,
+		Roots:          roots,
+
+		RefreshInterval: *refreshInterval,
+		// The lock file is written only once the port is bound, so it
+		// never points at a server that failed to start
+		OnListening: func() error {
+			if err := writeLockFile(*bind, actualPort); err != nil {
+				return fmt.Errorf("writing lock file: %w", err)
+			}
+			fmt.Printf("\n  LiveMD server started\n")
+			printBoundAddresses(*bind, actualPort)
+			fmt.Println("  Use 'livemd add <file.md>' to watch files")
+			fmt.Println("  Use 'livemd stop' to stop the server")
+			fmt.Println()
+			return nil
+		},
+	})
 }
 
-// isPortAvailable checks if a TCP port can be listened on.
-func isPortAvailable(port int) bool {
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// isPortAvailable checks if a TCP port can be listened on at host (all
+// interfaces when empty).
+func isPortAvailable(host string, port int) bool {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
 	if err != nil {
 		return false
 	}
@@ -180,14 +393,14 @@ func isPortAvailable(port int) bool {
 }
 
 // findAvailablePort scans upward from startPort to find the next available port.
-func findAvailablePort(startPort int) int {
+func findAvailablePort(host string, startPort int) int {
 	for p := startPort + 1; p <= startPort+100; p++ {
-		if isPortAvailable(p) {
+		if isPortAvailable(host, p) {
 			return p
 		}
 	}
 	// Fallback: let the OS pick
-	ln, err := net.Listen("tcp", ":0")
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
 	if err != nil {
 		return startPort
 	}
@@ -252,12 +465,32 @@ func printServerAddresses(port int) {
 	fmt.Println()
 }
 
+// printBoundAddresses prints the URLs of a server listening on bind. Bound
+// to all interfaces it prints every address like printServerAddresses;
+// bound to one address only that one is reachable.
+func printBoundAddresses(bind string, port int) {
+	ip := net.ParseIP(bind)
+	switch {
+	case bind == "" || ip != nil && ip.IsUnspecified():
+		printServerAddresses(port)
+		return
+	case bind == "localhost" || ip != nil && ip.IsLoopback():
+		fmt.Printf("  http://localhost:%d (local only)\n", port)
+	default:
+		fmt.Printf("  http://%s\n", net.JoinHostPort(bind, strconv.Itoa(port)))
+	}
+	fmt.Println()
+}
+
 // cmdAdd handles the "livemd add" command.
 // It adds files or directories to the server's watch list via the HTTP API.
 //
 // Flags:
 //   - -r, --recursive: Enable recursive directory scanning
 //   - --filter: Comma-separated list of extensions to include (e.g., "md,go,js")
+//   - --exclude: Comma-separated patterns of names or paths to skip
+//   - --dry-run: Print what would be added without contacting the server
+//   - --active: Watch the files for changes right away
 //
 // The function handles both WSL/Windows path conversion and supports adding
 // single files or entire directories with extension filtering.
@@ -265,28 +498,19 @@ func cmdAdd() {
 	fs := flag.NewFlagSet("add", flag.ExitOnError)
 	recursive := fs.Bool("r", false, "recursively add files from folder")
 	recursiveLong := fs.Bool("recursive", false, "recursively add files from folder")
-	filter := fs.String("filter", "", "filter by extensions (comma-separated, e.g. \"md,go,js\")")
+	filter := fs.String("filter", "", "filter by extensions (comma-separated, e.g. \"md,go,js\"; \"!min.js\" omits)")
+	exclude := fs.String("exclude", "", "skip names or paths matching these patterns (comma-separated, e.g. \"node_modules,*.min.js\")")
+	dryRun := fs.Bool("dry-run", false, "print the files that would be added without adding them")
+	quiet := fs.Bool("quiet", false, "print only the summary and errors, not each added file")
+	fs.BoolVar(quiet, "q", false, "print only the summary and errors, not each added file")
+	maxFiles := fs.Int("max-files", defaultMaxFiles, "ask before adding a folder with more files than this (0 for no limit)")
+	active := fs.Bool("active", false, "watch the files for changes right away, without choosing Watch in a browser")
+	yes := fs.Bool("yes", false, "add large folders without asking")
+	fs.BoolVar(yes, "y", false, "add large folders without asking")
+	server := addServerFlags(fs)
 
 	// Reorder args so flags come first (Go flag package stops at first positional arg)
-	args := os.Args[2:]
-	var flags []string
-	var positional []string
-	for i := 0; i < len(args); i++ {
-		arg := args[i]
-		if strings.HasPrefix(arg, "-") {
-			flags = append(flags, arg)
-			// Check if this flag takes a value
-			if (arg == "--filter" || arg == "-filter") && i+1 < len(args) {
-				i++
-				flags = append(flags, args[i])
-			}
-		} else {
-			positional = append(positional, arg)
-		}
-	}
-
-	reordered := append(flags, positional...)
-	fs.Parse(reordered)
+	fs.Parse(reorderArgs(os.Args[2:], "filter", "exclude", "host", "port", "name", "max-files"))
 
 	if fs.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "Usage: livemd add <file|folder> [-r] [--filter EXT]")
@@ -296,41 +520,33 @@ func cmdAdd() {
 	pathArg := fs.Arg(0)
 	isRecursive := *recursive || *recursiveLong
 
-	// Try path conversion for WSL/Windows interop
-	convertedPath := NormalizePath(pathArg)
+	// Remote URLs are fetched and polled by the server
+	if isRemotePath(pathArg) {
+		if *dryRun {
+			fmt.Printf("Would watch: %s\n", pathArg)
+			return
+		}
+		addSingleFile(pathArg, server.baseURL(), *active)
+		return
+	}
 
-	absPath, err := filepath.Abs(convertedPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error resolving path: %v\n", err)
-		os.Exit(1)
+	if pathArg == "-" {
+		addFromStdin(server, *dryRun, *quiet, *active)
+		return
 	}
 
-	// Try original path if converted doesn't exist
-	info, err := os.Stat(absPath)
+	absPath, info, err := resolveLocalPath(pathArg)
 	if os.IsNotExist(err) {
-		// Try the original path
-		origAbs, _ := filepath.Abs(pathArg)
-		if info2, err2 := os.Stat(origAbs); err2 == nil {
-			absPath = origAbs
-			info = info2
-		} else {
-			fmt.Fprintf(os.Stderr, "Path not found: %s\n", pathArg)
-			if convertedPath != pathArg {
-				fmt.Fprintf(os.Stderr, "  (tried: %s)\n", absPath)
-			}
-			os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Path not found: %s\n", pathArg)
+		if absPath != pathArg {
+			fmt.Fprintf(os.Stderr, "  (tried: %s)\n", absPath)
 		}
+		os.Exit(1)
 	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "Error accessing path: %v\n", err)
 		os.Exit(1)
 	}
 
-	port, err := readLockFile()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "LiveMD server not running. Start it with 'livemd start'")
-		os.Exit(1)
-	}
-
 	// Handle directory
 	if info.IsDir() {
 		if !isRecursive {
@@ -338,19 +554,122 @@ func cmdAdd() {
 			fmt.Fprintf(os.Stderr, "  Example: livemd add %s -r\n", pathArg)
 			os.Exit(1)
 		}
-		addFolder(absPath, port, *filter)
+		cfg := loadConfig()
+		if include, _ := parseFilter(*filter); len(include) == 0 {
+			// The server's --exts overrides the configured extensions
+			if exts := fetchServerExtensions(server); len(exts) > 0 {
+				cfg.Extensions = exts
+			}
+		}
+		filter := newFolderFilter(cfg, *filter, *exclude).withIgnoreFile(absPath)
+		if *dryRun {
+			listFolder(absPath, filter)
+			return
+		}
+		limit := *maxFiles
+		if *yes {
+			limit = 0
+		}
+		addFolder(absPath, server.baseURL(), filter, limit, *quiet, *active)
 		return
 	}
 
 	// Handle single file
-	addSingleFile(absPath, port)
+	if *dryRun {
+		fmt.Printf("Would watch: %s\n", absPath)
+		return
+	}
+	addSingleFile(absPath, server.baseURL(), *active)
+}
+
+// resolveLocalPath turns a path given on the command line into an absolute
+// path, converting between WSL and Windows forms when needed. If the converted
+// path doesn't exist the original is tried. On a not-exist error the returned
+// path is the converted one, for reporting.
+func resolveLocalPath(pathArg string) (string, os.FileInfo, error) {
+	// Try path conversion for WSL/Windows interop. Symlinks are left for the
+	// server to resolve, so it can keep the link's name for display.
+	convertedPath := CleanPath(pathArg)
+
+	absPath := convertedPath
+	if !isUNCPath(convertedPath) {
+		var err error
+		absPath, err = filepath.Abs(convertedPath)
+		if err != nil {
+			return pathArg, nil, err
+		}
+	}
+
+	info, err := os.Stat(absPath)
+	if os.IsNotExist(err) {
+		// Try the original path
+		origAbs, _ := filepath.Abs(pathArg)
+		if info2, err2 := os.Stat(origAbs); err2 == nil {
+			return origAbs, info2, nil
+		}
+	}
+	return absPath, info, err
+}
+
+// addFromStdin adds the newline-delimited paths read from stdin, as in
+// "git diff --name-only | livemd add -". Blank lines and lines starting
+// with # are skipped, as are paths that don't exist or are directories.
+func addFromStdin(server *serverFlags, dryRun, quiet, active bool) {
+	var files []string
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if isRemotePath(line) {
+			files = append(files, line)
+			continue
+		}
+
+		absPath, info, err := resolveLocalPath(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "  ! %s: %v\n", line, err)
+			continue
+		}
+		if info.IsDir() {
+			fmt.Fprintf(os.Stderr, "  ! %s: is a directory\n", line)
+			continue
+		}
+		files = append(files, absPath)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(files) == 0 {
+		fmt.Println("No files to add.")
+		return
+	}
+
+	if dryRun {
+		for _, file := range files {
+			fmt.Printf("  %s\n", file)
+		}
+		fmt.Printf("\nWould add %d file(s)\n", len(files))
+		return
+	}
+
+	addFiles(files, server.baseURL(), quiet, active)
+}
+
+// postWatch asks the server to watch path; with active set the file is
+// watched for changes right away rather than once a browser opens it.
+func postWatch(baseURL, path string, active bool) (*http.Response, error) {
+	body, _ := json.Marshal(map[string]interface{}{"path": path, "active": active})
+	return http.Post(baseURL+"/api/watch", "application/json", bytes.NewReader(body))
 }
 
 // addSingleFile sends a POST request to the server's /api/watch endpoint
-// to add a single file to the watch list. It reports success or failure to stdout/stderr.
-func addSingleFile(absPath string, port int) {
-	body, _ := json.Marshal(map[string]string{"path": absPath})
-	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/api/watch", port), "application/json", bytes.NewReader(body))
+// to add a single file (or remote URL) to the watch list. It reports success or failure to stdout/stderr.
+func addSingleFile(absPath string, baseURL string, active bool) {
+	resp, err := postWatch(baseURL, absPath, active)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error connecting to server: %v\n", err)
 		os.Exit(1)
@@ -358,56 +677,197 @@ func addSingleFile(absPath string, port int) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		fmt.Fprintf(os.Stderr, "Error: %s\n", string(respBody))
+		apiErr := readAPIError(resp)
+		// Re-adding a watched file is not a failure, like in addFiles
+		if apiErr.Code == codeAlreadyRegistered {
+			fmt.Printf("Already watching: %s\n", filepath.Base(absPath))
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Error: %s\n", apiErr.Message)
 		os.Exit(1)
 	}
 
 	fmt.Printf("Watching: %s\n", filepath.Base(absPath))
 }
 
-// addFolder recursively scans a directory and adds all matching files to the watch list.
-// It filters files by extension using either defaultExtensions or a custom filter.
-// Hidden directories (starting with ".") are skipped during traversal.
-// If more than 500 files are found, it prompts for user confirmation before proceeding.
-func addFolder(folderPath string, port int, filterExts string) {
-	// Build extension filter
-	allowedExts := defaultExtensions
-	if filterExts != "" {
-		allowedExts = []string{}
-		for _, ext := range strings.Split(filterExts, ",") {
-			ext = strings.TrimSpace(ext)
-			if !strings.HasPrefix(ext, ".") {
-				ext = "." + ext
+// folderFilter selects the files added from a folder.
+type folderFilter struct {
+	Extensions []string // allowed extensions, with leading dot
+	// OmitExtensions are name endings to skip despite Extensions, with
+	// leading dot (".min.js", ".lock")
+	OmitExtensions []string
+	Exclude        []string // patterns for names or relative paths to skip
+}
+
+// newFolderFilter merges the --filter and --exclude flags over the config.
+// A flag that is set replaces the configured list rather than extending it,
+// except that a --filter of only negations ("!lock,!min.js") subtracts from
+// the configured extensions.
+func newFolderFilter(cfg Config, filterExts, exclude string) folderFilter {
+	f := folderFilter{Extensions: cfg.Extensions, Exclude: cfg.Exclude}
+	include, omit := parseFilter(filterExts)
+	if len(include) > 0 {
+		f.Extensions = include
+	}
+	f.OmitExtensions = omit
+	if exclude != "" {
+		f.Exclude = splitList(exclude)
+	}
+	return f
+}
+
+// parseFilter splits a --filter list into the extensions to include and the
+// "!"-prefixed ones to omit, each with a leading dot. A leading "*" is
+// dropped, so "!*.min.js" omits the same files as "!min.js".
+func parseFilter(list string) (include, omit []string) {
+	for _, entry := range splitList(list) {
+		negated := strings.HasPrefix(entry, "!")
+		entry = strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(entry, "!")), "*")
+		if entry == "" || entry == "." {
+			continue
+		}
+		if negated {
+			omit = append(omit, parseExtensions(entry)...)
+		} else {
+			include = append(include, parseExtensions(entry)...)
+		}
+	}
+	return include, omit
+}
+
+// ignoreFileName is a file in the root of a folder added with "add -r" that
+// lists more exclude patterns, one per line, like a .gitignore.
+const ignoreFileName = ".livemdignore"
+
+// withIgnoreFile returns f with the patterns from folderPath's
+// .livemdignore, if it has one, added to the exclude list.
+func (f folderFilter) withIgnoreFile(folderPath string) folderFilter {
+	data, err := os.ReadFile(filepath.Join(folderPath, ignoreFileName))
+	if err != nil {
+		return f
+	}
+	exclude := append([]string(nil), f.Exclude...)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exclude = append(exclude, line)
+	}
+	f.Exclude = exclude
+	return f
+}
+
+// excluded reports whether rel, a path relative to the folder being added,
+// matches an exclude pattern. Patterns match either the base name
+// ("node_modules", "*.min.js") or the whole relative path ("docs/drafts/*").
+// A leading "/" matches the relative path only ("/build" but not "src/build").
+func (f folderFilter) excluded(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	name := rel[strings.LastIndex(rel, "/")+1:]
+	for _, pattern := range f.Exclude {
+		pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+		if anchored := strings.TrimPrefix(pattern, "/"); anchored != pattern {
+			if ok, _ := filepath.Match(anchored, rel); ok {
+				return true
 			}
-			allowedExts = append(allowedExts, strings.ToLower(ext))
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether the file's extension is one of f.Extensions and
+// its name doesn't end in one of f.OmitExtensions.
+func (f folderFilter) allowed(file string) bool {
+	name := strings.ToLower(filepath.Base(file))
+	for _, omit := range f.OmitExtensions {
+		if strings.HasSuffix(name, omit) {
+			return false
+		}
+	}
+	ext := filepath.Ext(name)
+	for _, allowed := range f.Extensions {
+		if ext == allowed {
+			return true
 		}
 	}
+	return false
+}
 
-	// Collect all matching files
+// print describes the filter, for output when nothing matched.
+func (f folderFilter) print() {
+	fmt.Printf("  Extensions: %s\n", strings.Join(f.Extensions, ","))
+	if len(f.OmitExtensions) > 0 {
+		fmt.Printf("  Not: %s\n", strings.Join(f.OmitExtensions, ","))
+	}
+	if len(f.Exclude) > 0 {
+		fmt.Printf("  Exclude: %s\n", strings.Join(f.Exclude, ","))
+	}
+}
+
+// collectFolderFiles recursively scans a directory for files to watch.
+// Hidden directories (starting with ".") and excluded paths are skipped.
+func collectFolderFiles(folderPath string, filter folderFilter) ([]string, error) {
 	var files []string
 	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip files we can't access
 		}
+		if path == folderPath {
+			return nil
+		}
+		rel, _ := filepath.Rel(folderPath, path)
 		if info.IsDir() {
-			// Skip hidden directories
-			if strings.HasPrefix(info.Name(), ".") && path != folderPath {
+			// Skip hidden and excluded directories
+			if strings.HasPrefix(info.Name(), ".") || filter.excluded(rel) {
 				return filepath.SkipDir
 			}
 			return nil
 		}
-		// Check extension
-		ext := strings.ToLower(filepath.Ext(path))
-		for _, allowed := range allowedExts {
-			if ext == allowed {
-				files = append(files, path)
-				break
-			}
+		if filter.allowed(path) && !filter.excluded(rel) {
+			files = append(files, path)
 		}
 		return nil
 	})
+	return files, err
+}
+
+// listFolder prints the files addFolder would add, without contacting the
+// server. Used by "livemd add --dry-run" to tune filters before adding.
+func listFolder(folderPath string, filter folderFilter) {
+	files, err := collectFolderFiles(folderPath, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error scanning folder: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, file := range files {
+		rel, err := filepath.Rel(folderPath, file)
+		if err != nil {
+			rel = file
+		}
+		fmt.Printf("  %s\n", rel)
+	}
+	fmt.Printf("\nWould add %d file(s) from %s\n", len(files), folderPath)
+	filter.print()
+}
 
+// defaultMaxFiles is how many files "add -r" adds without asking.
+const defaultMaxFiles = 500
+
+// addFolder recursively scans a directory and adds all matching files to the watch list.
+// If more than maxFiles files are found (0 for no limit), it prompts for user
+// confirmation before proceeding; without a terminal to ask on it cancels.
+// With quiet set, only the summary and errors are printed.
+func addFolder(folderPath string, baseURL string, filter folderFilter, maxFiles int, quiet, active bool) {
+	files, err := collectFolderFiles(folderPath, filter)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error scanning folder: %v\n", err)
 		os.Exit(1)
@@ -415,16 +875,18 @@ func addFolder(folderPath string, port int, filterExts string) {
 
 	if len(files) == 0 {
 		fmt.Println("No supported files found in folder.")
-		if filterExts != "" {
-			fmt.Printf("  Filter: %s\n", filterExts)
-		}
+		filter.print()
 		return
 	}
 
 	// Warn about large folder
-	const warnThreshold = 500
-	if len(files) > warnThreshold {
+	if maxFiles > 0 && len(files) > maxFiles {
 		fmt.Printf("Warning: Found %d files. This may affect performance.\n", len(files))
+		// Scripts and hooks have no one to answer; don't wait for them
+		if !isTerminal(os.Stdin) {
+			fmt.Fprintf(os.Stderr, "Cancelled: more than %d files and no terminal to confirm. Use --yes or --max-files.\n", maxFiles)
+			os.Exit(1)
+		}
 		fmt.Print("Continue? [y/N] ")
 		var response string
 		fmt.Scanln(&response)
@@ -434,14 +896,33 @@ func addFolder(folderPath string, port int, filterExts string) {
 		}
 	}
 
-	fmt.Printf("Found %d files in %s\n", len(files), folderPath)
+	if !quiet {
+		fmt.Printf("Found %d files in %s\n", len(files), folderPath)
+	}
+	addFiles(files, baseURL, quiet, active)
+}
 
-	// Add each file
-	added := 0
-	skipped := 0
+// isTerminal reports whether f is an interactive terminal rather than a
+// pipe, file or /dev/null.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device too, and is what hooks often get
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
+
+// addFiles adds each of files to the watch list, printing one line per file
+// (unless quiet) and a summary, and returns how many were added and how many
+// were already watched. Files that are already watched are counted but not
+// reported as errors.
+func addFiles(files []string, baseURL string, quiet, active bool) (added, skipped int) {
 	for _, file := range files {
-		body, _ := json.Marshal(map[string]string{"path": file})
-		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/api/watch", port), "application/json", bytes.NewReader(body))
+		resp, err := postWatch(baseURL, file, active)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "  Error: %s - %v\n", filepath.Base(file), err)
 			continue
@@ -449,49 +930,66 @@ func addFolder(folderPath string, port int, filterExts string) {
 
 		if resp.StatusCode == http.StatusOK {
 			added++
-			fmt.Printf("  + %s\n", filepath.Base(file))
+			if !quiet {
+				fmt.Printf("  + %s\n", filepath.Base(file))
+			}
 		} else {
-			respBody, _ := io.ReadAll(resp.Body)
-			// Don't print "already watching" as an error
-			if strings.Contains(string(respBody), "already watching") {
+			// Don't print already watched files as errors
+			if apiErr := readAPIError(resp); apiErr.Code == codeAlreadyRegistered {
 				skipped++
 			} else {
-				fmt.Fprintf(os.Stderr, "  ! %s: %s\n", filepath.Base(file), string(respBody))
+				fmt.Fprintf(os.Stderr, "  ! %s: %s\n", filepath.Base(file), apiErr.Message)
 			}
 		}
 		resp.Body.Close()
 	}
 
-	fmt.Printf("\nAdded %d file(s)", added)
+	if !quiet {
+		fmt.Println()
+	}
+	fmt.Printf("Added %d file(s)", added)
 	if skipped > 0 {
 		fmt.Printf(" (%d already watched)", skipped)
 	}
 	fmt.Println()
+	return added, skipped
+}
+
+// readAPIError decodes the error of a failed API response. A body that
+// isn't an apiError, e.g. from an older server, becomes the message.
+func readAPIError(resp *http.Response) apiError {
+	body, _ := io.ReadAll(resp.Body)
+	var apiErr apiError
+	if json.Unmarshal(body, &apiErr) != nil || apiErr.Message == "" {
+		apiErr = apiError{Message: strings.TrimSpace(string(body))}
+	}
+	return apiErr
 }
 
 // cmdRemove handles the "livemd remove" command.
 // It sends a DELETE request to the server's /api/watch endpoint to stop watching a file.
 // The file must be specified by its path, which will be resolved to an absolute path.
 func cmdRemove() {
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "Usage: livemd remove <file.md>")
-		os.Exit(1)
-	}
+	fs := flag.NewFlagSet("remove", flag.ExitOnError)
+	server := addServerFlags(fs)
+	fs.Parse(reorderArgs(os.Args[2:], "host", "port", "name"))
 
-	filePath := os.Args[2]
-	absPath, err := filepath.Abs(filePath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error resolving path: %v\n", err)
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: livemd remove <file.md>")
 		os.Exit(1)
 	}
 
-	port, err := readLockFile()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "LiveMD server not running.")
-		os.Exit(1)
+	absPath := fs.Arg(0)
+	if !isRemotePath(absPath) && !isStdinPath(absPath) {
+		var err error
+		absPath, err = filepath.Abs(absPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resolving path: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("http://localhost:%d/api/watch?path=%s", port, absPath), nil)
+	req, _ := http.NewRequest(http.MethodDelete, server.baseURL()+"/api/watch?path="+url.QueryEscape(absPath), nil)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error connecting to server: %v\n", err)
@@ -500,8 +998,7 @@ func cmdRemove() {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		respBody, _ := io.ReadAll(resp.Body)
-		fmt.Fprintf(os.Stderr, "Error: %s\n", string(respBody))
+		fmt.Fprintf(os.Stderr, "Error: %s\n", readAPIError(resp).Message)
 		os.Exit(1)
 	}
 
@@ -512,13 +1009,11 @@ func cmdRemove() {
 // It retrieves and displays all currently watched files from the server's /api/files endpoint.
 // For each file, it shows the filename, full path, tracking start time, and last change time.
 func cmdList() {
-	port, err := readLockFile()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "LiveMD server not running.")
-		os.Exit(1)
-	}
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	server := addServerFlags(fs)
+	fs.Parse(os.Args[2:])
 
-	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/api/files", port))
+	resp, err := http.Get(server.baseURL() + "/api/files")
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error connecting to server: %v\n", err)
 		os.Exit(1)
@@ -536,26 +1031,137 @@ func cmdList() {
 
 	fmt.Printf("Watching %d file(s):\n\n", len(files))
 	for _, f := range files {
-		fmt.Printf("  %s\n", f.Name)
+		if f.Label != "" {
+			fmt.Printf("  %s (%s)\n", f.Label, f.Name)
+		} else {
+			fmt.Printf("  %s\n", f.Name)
+		}
 		fmt.Printf("    Path: %s\n", f.Path)
+		fmt.Printf("    Size: %s, %d lines\n", formatSize(f.Size), f.Lines)
 		fmt.Printf("    Tracking since: %s\n", f.TrackTime.Format("2006-01-02 15:04:05"))
 		fmt.Printf("    Last change: %s\n", f.LastChange.Format("2006-01-02 15:04:05"))
 		fmt.Println()
 	}
 }
 
+// cmdExportSession handles the "livemd export-session" command. It prints a
+// shell script of "livemd add" lines that recreates the server's watch list,
+// with --active for files being watched for changes. Deleted files are
+// listed as comments.
+func cmdExportSession() {
+	fs := flag.NewFlagSet("export-session", flag.ExitOnError)
+	server := addServerFlags(fs)
+	fs.Parse(os.Args[2:])
+
+	resp, err := http.Get(server.baseURL() + "/api/files")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to server: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	var files []WatchedFile
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file list: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("#!/bin/sh")
+	fmt.Printf("# livemd session exported %s (%d file(s))\n", time.Now().Format("2006-01-02 15:04"), len(files))
+	for _, f := range files {
+		// Symlinks are re-added through the link, like the state file does
+		path := f.Path
+		if f.LinkPath != "" {
+			path = f.LinkPath
+		}
+		if f.Deleted {
+			fmt.Printf("# deleted: %s\n", path)
+			continue
+		}
+		if isStdinPath(path) {
+			fmt.Printf("# piped, pipe it again with livemd preview: %s\n", path)
+			continue
+		}
+		line := "livemd add " + shellQuote(path)
+		if f.Active {
+			line += " --active"
+		}
+		fmt.Println(line)
+	}
+}
+
+// shellQuote quotes s for a POSIX shell, leaving plain words alone.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:@%+=,") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// listFlag is a flag that may be repeated; each value may also be a
+// comma-separated list.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// formatSize formats a byte count for display, e.g. "1.5 KB".
+func formatSize(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+}
+
+// parseSize parses a size like "10MB", "500KB", "1.5GB" or a plain byte count.
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
 // cmdStop handles the "livemd stop" command.
 // It sends a POST request to the server's /api/shutdown endpoint to initiate graceful shutdown.
 // The lock file is removed regardless of whether the server responds (it may have already exited).
 func cmdStop() {
-	port, err := readLockFile()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "LiveMD server not running.")
-		os.Exit(1)
-	}
+	fs := flag.NewFlagSet("stop", flag.ExitOnError)
+	server := addServerFlags(fs)
+	fs.Parse(os.Args[2:])
 
-	resp, err := http.Post(fmt.Sprintf("http://localhost:%d/api/shutdown", port), "", nil)
+	resp, err := http.Post(server.baseURL()+"/api/shutdown", "", nil)
 	if err != nil {
+		if !server.isLocal() {
+			fmt.Fprintf(os.Stderr, "Error connecting to server: %v\n", err)
+			os.Exit(1)
+		}
 		// Server might have already shut down
 		removeLockFile()
 		fmt.Println("LiveMD server stopped.")
@@ -563,10 +1169,201 @@ func cmdStop() {
 	}
 	defer resp.Body.Close()
 
-	removeLockFile()
+	// The lock file belongs to a local server; a remote one cleans up its own
+	if server.isLocal() {
+		removeLockFile()
+	}
 	fmt.Println("LiveMD server stopped.")
 }
 
+// waitPollInterval is how often "livemd wait" checks for the server.
+const waitPollInterval = 100 * time.Millisecond
+
+// cmdWait handles the "livemd wait" command.
+// It polls until the lock file names a port and the server there answers
+// /healthz, so scripts can run "livemd start &" and add files right after.
+// It exits with an error when the server isn't up within --timeout.
+func cmdWait() {
+	fs := flag.NewFlagSet("wait", flag.ExitOnError)
+	server := addServerFlags(fs)
+	timeout := fs.Duration("timeout", 10*time.Second, "how long to wait for the server")
+	fs.Parse(os.Args[2:])
+
+	if !server.isLocal() && *server.port == 0 {
+		// No lock file to wait for; fails with the reason
+		server.baseURL()
+	}
+
+	client := &http.Client{Timeout: time.Second}
+	deadline := time.Now().Add(*timeout)
+	for {
+		// The lock file is read again each time, as it appears once the
+		// server has bound its port
+		baseURL, err := server.lookupBaseURL()
+		if err == nil {
+			var resp *http.Response
+			resp, err = client.Get(baseURL + "/healthz")
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					fmt.Printf("LiveMD server is ready at %s\n", baseURL)
+					return
+				}
+				err = fmt.Errorf("%s/healthz answered %s", baseURL, resp.Status)
+			}
+		}
+		if time.Now().After(deadline) {
+			fmt.Fprintf(os.Stderr, "LiveMD server not ready after %s: %v\n", *timeout, err)
+			os.Exit(1)
+		}
+		time.Sleep(waitPollInterval)
+	}
+}
+
+// cmdPreview handles the "livemd preview" command.
+// It sends what is piped to stdin to the server as a document shown at a
+// stable URL. Running it again with the same --as name updates that
+// document in place.
+func cmdPreview() {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	name := fs.String("as", defaultStdinName, "name of the document; its extension picks how it is rendered")
+	server := addServerFlags(fs)
+	fs.Parse(reorderArgs(os.Args[2:], "as", "host", "port", "name"))
+
+	if isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "Usage: <command> | livemd preview [--as NAME]")
+		os.Exit(1)
+	}
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading stdin: %v\n", err)
+		os.Exit(1)
+	}
+
+	baseURL := server.baseURL()
+	req, _ := http.NewRequest(http.MethodPut, baseURL+"/api/stdin?name="+url.QueryEscape(*name), bytes.NewReader(content))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to server: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", readAPIError(resp).Message)
+		os.Exit(1)
+	}
+	var result struct {
+		Path string `json:"path"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	fmt.Printf("Previewing %s at %s/#file=%s\n", *name, baseURL, url.QueryEscape(result.Path))
+}
+
+// cmdCheck handles the "livemd check" command.
+// It reports the broken links and images of markdown files, reading them
+// directly rather than asking the server, and exits with status 1 when any
+// are found. -r follows links to other markdown files, --external probes
+// web links.
+func cmdCheck() {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "also check the markdown files linked to")
+	fs.BoolVar(recursive, "recursive", false, "also check the markdown files linked to")
+	external := fs.Bool("external", false, "probe http(s) links with HEAD requests")
+	fs.Parse(reorderArgs(os.Args[2:]))
+
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: livemd check <file.md>... [-r] [--external]")
+		os.Exit(1)
+	}
+
+	cwd, _ := os.Getwd()
+	display := func(path string) string {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+		return path
+	}
+
+	checked := make(map[string]bool)
+	files, broken := 0, 0
+	failed := false
+	for _, arg := range fs.Args() {
+		absPath, _, err := resolveLocalPath(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't check %s: %v\n", arg, err)
+			failed = true
+			continue
+		}
+		if !isMarkdown(absPath) {
+			fmt.Fprintf(os.Stderr, "Not a markdown file: %s\n", arg)
+			failed = true
+			continue
+		}
+		result, err := checkLinks(absPath, nil, *recursive, *external)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't check %s: %v\n", arg, err)
+			failed = true
+			continue
+		}
+		for _, f := range result.Files {
+			if !checked[f] {
+				checked[f] = true
+				files++
+			}
+		}
+		for _, b := range result.Broken {
+			kind := "link"
+			if b.Image {
+				kind = "image"
+			}
+			fmt.Printf("%s:%d: %s %s: %s\n", display(b.File), b.Line, kind, b.Target, b.Error)
+			broken++
+		}
+	}
+
+	noun := "files"
+	if files == 1 {
+		noun = "file"
+	}
+	if broken == 0 {
+		fmt.Printf("No broken links in %d %s\n", files, noun)
+	} else {
+		fmt.Printf("%d broken links in %d %s\n", broken, files, noun)
+	}
+	if broken > 0 || failed {
+		os.Exit(1)
+	}
+}
+
+// cmdVersion handles the "livemd version" command.
+// It prints the CLI's version and, when a server is running, the server's
+// version too, which can differ after an update until the server restarts.
+func cmdVersion() {
+	fmt.Printf("livemd %s %s/%s\n", Version, runtime.GOOS, runtime.GOARCH)
+
+	host, port, err := readLockAddress()
+	if err != nil {
+		return
+	}
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get("http://" + net.JoinHostPort(host, strconv.Itoa(port)) + "/api/status")
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var status StatusInfo
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&status) != nil {
+		// Servers before /api/status existed answer with a 404 page
+		fmt.Printf("server: running on port %d (version unknown)\n", port)
+		return
+	}
+	fmt.Printf("server: livemd %s %s/%s on port %d\n", status.Version, status.OS, status.Arch, status.Port)
+	if status.Version != Version {
+		fmt.Println("  The running server is a different version; restart it with 'livemd stop' and 'livemd start'")
+	}
+}
+
 // cmdPort handles the "livemd port" command.
 // With no arguments, it displays the current configured port.
 // With a port number argument, it sets the default port for future server starts.
@@ -596,79 +1393,130 @@ func cmdPort() {
 	fmt.Printf("Default port set to %d\n", port)
 }
 
-// Config file helpers
+// Instances
 //
-// The config file stores user preferences like the default port.
-// Location: ~/.livemd.conf (Unix) or %APPDATA%/livemd.conf (Windows)
+// Several servers can run side by side (say one per project) when each is
+// given a name with --name or LIVEMD_NAME. The name is added to the lock and
+// state file names, and CLI commands with the same --name talk to that server.
 
-func getConfigFilePath() string {
-	if runtime.GOOS == "windows" {
-		appData := os.Getenv("APPDATA")
-		if appData == "" {
-			appData = os.Getenv("USERPROFILE")
-		}
-		return filepath.Join(appData, "livemd.conf")
-	}
-	home, _ := os.UserHomeDir()
-	return filepath.Join(home, ".livemd.conf")
+// instanceName is the server instance the command works with; "" is the
+// default instance.
+var instanceName = os.Getenv("LIVEMD_NAME")
+
+var instanceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// addNameFlag registers --name on fs, which selects the server instance.
+func addNameFlag(fs *flag.FlagSet) {
+	fs.Func("name", "name of the server instance (env LIVEMD_NAME)", setInstanceName)
 }
 
-func readConfigPort() int {
-	data, err := os.ReadFile(getConfigFilePath())
-	if err != nil {
-		return 3000
+func setInstanceName(name string) error {
+	if name != "" && !instanceNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid instance name %q (use letters, digits, - and _)", name)
 	}
-	for _, line := range strings.Split(string(data), "\n") {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "port=") {
-			if p, err := strconv.Atoi(strings.TrimPrefix(line, "port=")); err == nil && p > 0 && p <= 65535 {
-				return p
-			}
+	instanceName = name
+	return nil
+}
+
+// preparseName applies a --name flag found in args ahead of flag parsing.
+func preparseName(args []string) error {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		flagName := strings.TrimLeft(arg, "-")
+		if flagName == arg {
+			continue
+		}
+		if value, ok := strings.CutPrefix(flagName, "name="); ok {
+			return setInstanceName(value)
+		}
+		if flagName == "name" && i+1 < len(args) {
+			return setInstanceName(args[i+1])
 		}
 	}
-	return 3000
+	return nil
 }
 
-func writeConfigPort(port int) error {
-	return os.WriteFile(getConfigFilePath(), []byte(fmt.Sprintf("port=%d\n", port)), 0644)
+// instanceFileName returns base with the instance name added before ext,
+// e.g. "livemd-docs.lock" for instance "docs".
+func instanceFileName(base, ext string) string {
+	if instanceName == "" {
+		return base + ext
+	}
+	return base + "-" + instanceName + ext
 }
 
 // Lock file helpers
 //
-// The lock file stores the server's port number and serves two purposes:
+// The lock file stores the server's address (e.g. ":3000", or
+// "192.168.1.20:3000" with --bind) and serves two purposes:
 // 1. Prevents multiple server instances from running simultaneously
 // 2. Allows CLI commands to discover and communicate with the running server
 //
-// Location: ~/.livemd.lock (Unix) or %APPDATA%/livemd.lock (Windows)
+// Location: /tmp/livemd.lock (Unix) or %APPDATA%/livemd.lock (Windows), or
+// livemd-NAME.lock for a named instance. LIVEMD_HOME replaces the directory.
 
 // getLockFilePath returns the platform-specific path for the lock file.
 // On Unix, uses /tmp/livemd.lock so any user can discover the running server.
 // On Windows, uses APPDATA or USERPROFILE.
 func getLockFilePath() string {
+	if home := livemdHome(); home != "" {
+		return filepath.Join(home, instanceFileName("livemd", ".lock"))
+	}
 	if runtime.GOOS == "windows" {
 		appData := os.Getenv("APPDATA")
 		if appData == "" {
 			appData = os.Getenv("USERPROFILE")
 		}
-		return filepath.Join(appData, "livemd.lock")
+		return filepath.Join(appData, instanceFileName("livemd", ".lock"))
 	}
-	return "/tmp/livemd.lock"
+	return filepath.Join("/tmp", instanceFileName("livemd", ".lock"))
 }
 
-// writeLockFile creates the lock file containing the server's port number.
+// writeLockFile creates the lock file containing the address the server
+// listens on: its --bind address (empty for all interfaces) and port.
 // Called by cmdStart after verifying no existing server is running.
-func writeLockFile(port int) error {
-	return os.WriteFile(getLockFilePath(), []byte(strconv.Itoa(port)), 0644)
+func writeLockFile(bind string, port int) error {
+	return os.WriteFile(getLockFilePath(), []byte(net.JoinHostPort(bind, strconv.Itoa(port))), 0644)
 }
 
 // readLockFile reads the port number from the lock file.
 // Returns an error if the lock file doesn't exist (server not running) or is invalid.
 func readLockFile() (int, error) {
+	_, port, err := readLockAddress()
+	return port, err
+}
+
+// readLockAddress reads the host CLI commands reach the server on and its
+// port from the lock file. The host is the --bind address, or localhost
+// when the server listens on all interfaces. Lock files of older servers
+// hold only the port.
+func readLockAddress() (string, int, error) {
 	data, err := os.ReadFile(getLockFilePath())
 	if err != nil {
-		return 0, err
+		return "", 0, err
+	}
+	addr := strings.TrimSpace(string(data))
+	bind, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		bind, portStr = "", addr
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, err
 	}
-	return strconv.Atoi(strings.TrimSpace(string(data)))
+	return connectHost(bind), port, nil
+}
+
+// connectHost returns the host to connect to for a server bound to bind:
+// bind itself, or localhost for the wildcard addresses.
+func connectHost(bind string) string {
+	switch bind {
+	case "", "0.0.0.0", "::":
+		return "localhost"
+	}
+	return bind
 }
 
 // removeLockFile deletes the lock file during server shutdown.
@@ -676,3 +1524,120 @@ func readLockFile() (int, error) {
 func removeLockFile() {
 	os.Remove(getLockFilePath())
 }
+
+// Server connection helpers
+//
+// CLI commands talk to http://localhost:PORT by default, with the port taken
+// from the lock file, or to the server's --bind address when it was started
+// with one, as it may not listen on localhost then. --host (or LIVEMD_HOST) points them at a server on
+// another machine, e.g. one running in a container or VM. The lock file only
+// describes a server on this machine, so a remote host also needs --port.
+
+// serverFlags holds the --host and --port flags of commands that talk to a
+// running server.
+type serverFlags struct {
+	host *string
+	port *int
+}
+
+// addServerFlags registers --host, --port and --name on fs.
+func addServerFlags(fs *flag.FlagSet) *serverFlags {
+	addNameFlag(fs)
+	host := os.Getenv("LIVEMD_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	return &serverFlags{
+		host: fs.String("host", host, "host of the livemd server (env LIVEMD_HOST)"),
+		port: fs.Int("port", 0, "port of the livemd server (default from the lock file)"),
+	}
+}
+
+// isLocal reports whether the flags point at a server on this machine.
+func (s *serverFlags) isLocal() bool {
+	switch strings.ToLower(*s.host) {
+	case "localhost", "127.0.0.1", "::1", "[::1]":
+		return true
+	}
+	return false
+}
+
+// baseURL returns the server's base URL, e.g. http://localhost:3000.
+// It exits with a message when no server can be located.
+func (s *serverFlags) baseURL() string {
+	u, err := s.lookupBaseURL()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return u
+}
+
+// lookupBaseURL is like baseURL but returns an error instead of exiting.
+func (s *serverFlags) lookupBaseURL() (string, error) {
+	host := strings.TrimSuffix(strings.TrimPrefix(*s.host, "["), "]")
+	port := *s.port
+	if port == 0 {
+		if !s.isLocal() {
+			return "", fmt.Errorf("--port is required with a remote --host (%s)", *s.host)
+		}
+		// A server started with --bind may not listen on localhost
+		lockHost, lockPort, err := readLockAddress()
+		if err != nil {
+			if instanceName != "" {
+				return "", fmt.Errorf("LiveMD server %q not running. Start it with 'livemd start --name %s'", instanceName, instanceName)
+			}
+			return "", fmt.Errorf("LiveMD server not running. Start it with 'livemd start'")
+		}
+		host, port = lockHost, lockPort
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// fetchServerExtensions returns the extensions the server was started with
+// (--exts), or nil if it has none or can't be reached.
+func fetchServerExtensions(server *serverFlags) []string {
+	baseURL, err := server.lookupBaseURL()
+	if err != nil {
+		return nil
+	}
+	resp, err := http.Get(baseURL + "/api/extensions")
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Extensions []string `json:"extensions"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&result) != nil {
+		return nil
+	}
+	return result.Extensions
+}
+
+// reorderArgs moves flags in front of positional arguments, since the flag
+// package stops parsing at the first positional argument. valueFlags lists
+// the flags (without dashes) that take a separate value.
+func reorderArgs(args []string, valueFlags ...string) []string {
+	var flags []string
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") && arg != "-" { // "-" alone means stdin
+			flags = append(flags, arg)
+			// Check if this flag takes a value
+			name := strings.TrimLeft(arg, "-")
+			for _, vf := range valueFlags {
+				if name == vf && i+1 < len(args) {
+					i++
+					flags = append(flags, args[i])
+					break
+				}
+			}
+		} else {
+			positional = append(positional, arg)
+		}
+	}
+	return append(flags, positional...)
+}
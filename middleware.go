@@ -0,0 +1,227 @@
+package main
+
+import (
+	"compress/gzip"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// gzipHandler compresses responses for clients that accept gzip.
+// WebSocket upgrades are passed through untouched; the WebSocket connection
+// negotiates its own compression (permessage-deflate).
+func gzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Byte ranges refer to the uncompressed content, so serve whole files
+		r.Header.Del("Range")
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// gzipResponseWriter compresses the body written through it. Compression
+// starts with the first header write so that bodiless responses (204, 304)
+// are left alone.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		// Sniff from the uncompressed bytes; the gzip stream would otherwise
+		// be detected as application/x-gzip
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// Flush sends any buffered compressed data to the client.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the gzip stream.
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
+
+// originPolicy decides which browser origins may use the API and the
+// WebSocket. Without it any website open in the browser could talk to
+// livemd, e.g. through DNS rebinding.
+//
+// Pages served from localhost or a loopback address are always allowed.
+// With no --allow-origin, so are pages on this machine's LAN addresses;
+// otherwise the listed origins are allowed instead. An entry is a full
+// origin ("http://docs.example.com:8080"), a bare hostname matching any
+// scheme and port, or "*" to allow everything. A page whose origin is the
+// host it requested (a UI reached by hostname or through a reverse proxy)
+// is allowed too.
+//
+// The Host header is checked as well, since a rebound page sends no Origin
+// with its GETs. It must be localhost, an IP address, this machine's
+// hostname or the host of an --allow-origin entry; a rebinding attack needs
+// a domain name of the attacker's.
+type originPolicy struct {
+	allowed []string
+	lan     []string        // this machine's LAN addresses
+	hosts   map[string]bool // hostnames allowed in the Host header
+	any     bool            // "*" was allowed
+}
+
+func newOriginPolicy(allowed []string) *originPolicy {
+	p := &originPolicy{lan: getNetworkAddresses(), hosts: make(map[string]bool)}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		name = strings.ToLower(name)
+		p.hosts[name] = true
+		p.hosts[name+".local"] = true
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(a), "/"))
+		if a == "" {
+			continue
+		}
+		p.allowed = append(p.allowed, a)
+		if a == "*" {
+			p.any = true
+		} else if u, err := url.Parse(a); err == nil && u.Host != "" {
+			p.hosts[u.Hostname()] = true
+		} else {
+			p.hosts[a] = true
+		}
+	}
+	return p
+}
+
+// allows reports whether r may be served: its Host must be one the server
+// is known under, and its Origin, if any, allowed. Requests without an
+// Origin don't come from a cross-origin web page (livemd's own CLI, curl)
+// and need only pass the Host check.
+func (p *originPolicy) allows(r *http.Request) bool {
+	if !p.allowsHost(r.Host) {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true // same origin
+	}
+	host := strings.ToLower(u.Hostname())
+	if isLocalHost(host) {
+		return true
+	}
+
+	if len(p.allowed) == 0 {
+		for _, addr := range p.lan {
+			if host == addr {
+				return true
+			}
+		}
+		return false
+	}
+	origin = strings.ToLower(origin)
+	for _, a := range p.allowed {
+		if a == "*" || a == origin || a == host {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHost reports whether a request's Host header names this server.
+// An empty Host (HTTP/1.0 clients) is allowed.
+func (p *originPolicy) allowsHost(hostport string) bool {
+	if hostport == "" || p.any {
+		return true
+	}
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	return isLocalHost(host) || net.ParseIP(host) != nil || p.hosts[host]
+}
+
+// isLocalHost reports whether host is localhost or a loopback address.
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkOrigin is the WebSocket upgrader's CheckOrigin.
+func (p *originPolicy) checkOrigin(r *http.Request) bool {
+	return p.allows(r)
+}
+
+// originHandler rejects requests from origins the policy doesn't allow and
+// answers CORS for the ones it does, so allowed pages elsewhere can call
+// the API too.
+func originHandler(p *originPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !p.allows(r) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+		if origin != "" {
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
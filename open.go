@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// openInEditor opens path in $VISUAL or $EDITOR, which may include
+// arguments (e.g. "code -w"). Without either, or when the editor runs in a
+// terminal, the OS's default application for the file is used: the server
+// starts the editor detached, with no terminal for it to draw in.
+func openInEditor(path string) error {
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 || needsTerminal(editor) {
+		return startDetached(defaultOpenCommand(path)...)
+	}
+	return startDetached(append(editor, path)...)
+}
+
+// terminalEditors are editors that only run in a terminal.
+var terminalEditors = map[string]bool{
+	"vi": true, "vim": true, "nvim": true, "view": true, "nano": true,
+	"pico": true, "micro": true, "hx": true, "helix": true, "kak": true,
+	"joe": true, "jed": true, "ne": true, "mg": true, "ed": true,
+	"mcedit": true,
+}
+
+// needsTerminal reports whether an editor command runs in a terminal:
+// a known terminal editor, or emacs told not to open a window.
+func needsTerminal(editor []string) bool {
+	name := strings.ToLower(filepath.Base(editor[0]))
+	name = strings.TrimSuffix(name, ".exe")
+	if terminalEditors[name] {
+		return true
+	}
+	if name == "emacs" || name == "emacsclient" {
+		for _, arg := range editor[1:] {
+			if arg == "-nw" || arg == "-t" || arg == "--no-window-system" || arg == "--tty" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// revealInFileManager shows path in the OS file browser, selecting it
+// where the file manager supports that.
+func revealInFileManager(path string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return startDetached("open", "-R", path)
+	case "windows":
+		return startDetached("explorer", "/select,"+path)
+	default:
+		return startDetached("xdg-open", filepath.Dir(path))
+	}
+}
+
+// defaultOpenCommand returns the command opening path in the OS's default
+// application. On Windows it isn't run through cmd, which would re-parse
+// characters such as & and | in the file name as commands.
+func defaultOpenCommand(path string) []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"open", path}
+	case "windows":
+		return []string{"rundll32", "url.dll,FileProtocolHandler", path}
+	default:
+		return []string{"xdg-open", path}
+	}
+}
+
+// startDetached starts a command without waiting for it to exit; the
+// process is reaped in the background.
+func startDetached(args ...string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
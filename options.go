@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+)
+
+// Per-file options
+//
+// A watched file can be rendered unlike the server's settings, e.g. one
+// markdown file as slides or one source file in another theme:
+//
+//	curl -X POST 'localhost:3000/api/files/options?path=/abs/notes.md' -d '{"slides": true}'
+//
+// The options are kept on the WatchedFile and in the state file, and every
+// render of the file uses them. Unset options follow the server.
+
+// FileOptions are the render options of one file. The zero value renders
+// as the server is configured.
+type FileOptions struct {
+	Slides   bool   `json:"slides,omitempty"`   // render markdown as a slide deck, without "mode: slides" front matter
+	Theme    string `json:"theme,omitempty"`    // chroma style for highlighting, instead of --theme
+	MaxLines *int   `json:"maxLines,omitempty"` // lines of a code file to render, instead of --max-lines (0 for no limit)
+}
+
+// IsZero reports whether o changes nothing.
+func (o FileOptions) IsZero() bool {
+	return !o.Slides && o.Theme == "" && o.MaxLines == nil
+}
+
+// Validate checks the options before they are stored.
+func (o FileOptions) Validate() error {
+	if o.Theme != "" {
+		if _, ok := styles.Registry[o.Theme]; !ok {
+			return fmt.Errorf("unknown theme %q", o.Theme)
+		}
+	}
+	if o.MaxLines != nil && *o.MaxLines < 0 {
+		return fmt.Errorf("maxLines must not be negative")
+	}
+	return nil
+}
+
+// withOptions returns a renderer for a file with opts, sharing this one's
+// cache and includes. Without options it is r itself.
+func (r *Renderer) withOptions(opts *FileOptions) *Renderer {
+	if opts == nil || opts.IsZero() {
+		return r
+	}
+	c := *r
+	if opts.Theme != "" && opts.Theme != r.config.Style {
+		c.config.Style = opts.Theme
+		c.md = r.styled.get(opts.Theme, func() goldmark.Markdown {
+			return newMarkdown(c.config, r.plantuml)
+		})
+	}
+	if opts.MaxLines != nil {
+		c.config.MaxLines = *opts.MaxLines
+	}
+	c.slides = opts.Slides
+	c.cacheTag = fmt.Sprintf("\x00options:%t:%s:%d:", opts.Slides, c.config.Style, c.config.MaxLines)
+	return &c
+}
+
+// styledMarkdown holds the markdown converters built for highlight styles
+// other than the configured one, each built when first used.
+type styledMarkdown struct {
+	mu      sync.Mutex
+	byStyle map[string]goldmark.Markdown
+}
+
+func (s *styledMarkdown) get(style string, build func() goldmark.Markdown) goldmark.Markdown {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	md, ok := s.byStyle[style]
+	if !ok {
+		md = build()
+		s.byStyle[style] = md
+	}
+	return md
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Partial updates
+//
+// With start --partial-updates, an update of a large document sends only the
+// top-level blocks of its HTML that changed, instead of the whole render.
+// The blocks between the first and the last change are replaced as one run,
+// which covers the usual edit in one place of the document.
+//
+// A patch names the Revision it applies to. A browser holding another
+// revision (e.g. a file list overtook the update) takes the whole file
+// instead. HTML that doesn't split into well-nested top-level elements is
+// always sent whole.
+
+// partialUpdateMinSize is the smallest HTML, in bytes, sent as a patch.
+// Smaller documents redraw quickly enough whole.
+const partialUpdateMinSize = 16 << 10
+
+// HTMLPatch replaces a run of top-level blocks of a file's HTML. Offsets and
+// lengths are in UTF-16 code units, as browsers index strings.
+type HTMLPatch struct {
+	Base   int    `json:"base"`   // Revision of the HTML the patch applies to
+	Blocks int    `json:"blocks"` // number of blocks in that HTML
+	Start  int    `json:"start"`  // index of the first replaced block
+	Remove int    `json:"remove"` // number of blocks replaced
+	Offset int    `json:"offset"` // where the replaced blocks start in the HTML
+	Length int    `json:"length"` // length of the replaced blocks in the HTML
+	HTML   string `json:"html"`   // the blocks replacing them
+}
+
+// partialUpdate returns the update message for f, holding a patch against
+// the HTML last sent for f when that is worth it, and the whole file
+// otherwise. The lock is only held to copy f and to store its blocks;
+// splitting and diffing a large document run without it.
+func (h *Hub) partialUpdate(f *WatchedFile) Message {
+	h.mu.RLock()
+	update := *f
+	h.mu.RUnlock()
+
+	var blocks []string
+	if len(update.HTML) >= partialUpdateMinSize {
+		blocks = splitBlocks(update.HTML)
+	}
+
+	h.mu.Lock()
+	live, exists := h.files[update.Path]
+	if !exists || live.Revision != update.Revision {
+		// Rendered again meanwhile: the update for the newer revision
+		// stores its own blocks
+		h.mu.Unlock()
+		return Message{Type: "update", File: &update}
+	}
+	prev, base := live.blocks, live.blocksRevision
+	live.blocks, live.blocksRevision = blocks, update.Revision
+	h.mu.Unlock()
+
+	if blocks == nil || prev == nil || base == update.Revision {
+		return Message{Type: "update", File: &update}
+	}
+	patch := diffBlocks(prev, blocks)
+	if len(patch.HTML) > len(update.HTML)/2 {
+		return Message{Type: "update", File: &update}
+	}
+	patch.Base = base
+	update.HTML = ""
+	return Message{Type: "update", File: &update, Patch: &patch}
+}
+
+// diffBlocks returns the patch turning the blocks prev into next, without
+// its Base.
+func diffBlocks(prev, next []string) HTMLPatch {
+	start := 0
+	for start < len(prev) && start < len(next) && prev[start] == next[start] {
+		start++
+	}
+	end := 0
+	for end < len(prev)-start && end < len(next)-start && prev[len(prev)-1-end] == next[len(next)-1-end] {
+		end++
+	}
+
+	offset := 0
+	for _, b := range prev[:start] {
+		offset += utf16Len(b)
+	}
+	length := 0
+	for _, b := range prev[start : len(prev)-end] {
+		length += utf16Len(b)
+	}
+	return HTMLPatch{
+		Blocks: len(prev),
+		Start:  start,
+		Remove: len(prev) - start - end,
+		Offset: offset,
+		Length: length,
+		HTML:   strings.Join(next[start:len(next)-end], ""),
+	}
+}
+
+// voidElements have no end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// splitBlocks splits HTML into its top-level elements. Each block holds one
+// element and the whitespace and comments after it; the first also holds
+// what comes before the first element. It returns nil when s has text
+// outside elements or tags that don't nest, which browsers would parse into
+// a different tree.
+func splitBlocks(s string) []string {
+	z := html.NewTokenizer(strings.NewReader(s))
+	var blocks []string
+	var cur strings.Builder
+	hasElement := false
+	var stack []string
+	total := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF || len(stack) > 0 {
+				return nil
+			}
+			break
+		}
+		// Copied, as TagName lower-cases the tag in place
+		raw := string(z.Raw())
+		total += len(raw)
+
+		if len(stack) == 0 {
+			switch tt {
+			case html.StartTagToken, html.SelfClosingTagToken:
+				if hasElement {
+					blocks = append(blocks, cur.String())
+					cur.Reset()
+				}
+				hasElement = true
+			case html.EndTagToken:
+				return nil
+			case html.TextToken:
+				if strings.TrimSpace(raw) != "" {
+					return nil
+				}
+			}
+		}
+
+		switch tt {
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if !voidElements[string(name)] {
+				stack = append(stack, string(name))
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if voidElements[string(name)] {
+				break
+			}
+			if stack[len(stack)-1] != string(name) {
+				return nil
+			}
+			stack = stack[:len(stack)-1]
+		}
+		cur.WriteString(raw)
+	}
+	if !hasElement || total != len(s) {
+		return nil
+	}
+	return append(blocks, cur.String())
+}
+
+// utf16Len is the length of s in UTF-16 code units.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
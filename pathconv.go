@@ -1,14 +1,26 @@
 package main
 
 import (
+	"bytes"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"unicode/utf16"
 )
 
+// wslSharePattern matches \\wsl$\Distro\... and \\wsl.localhost\Distro\...
+// paths and captures the path inside the distro. Distro names may contain
+// hyphens, dots and digits (Ubuntu-22.04), and the subpath may contain spaces.
+var wslSharePattern = regexp.MustCompile(`(?i)^\\\\wsl(?:\$|\.localhost)\\[^\\]+(\\.*)?$`)
+
+// statPath is os.Stat; tests replace it to convert paths to WSL shares
+// that don't exist on the machine running them.
+var statPath = os.Stat
+
 // ConvertPath attempts to convert between WSL and Windows paths
 func ConvertPath(path string) string {
 	if runtime.GOOS == "windows" {
@@ -27,7 +39,7 @@ func convertToWindowsPath(path string) string {
 	// Check if it's a /mnt/X/ path (WSL mounted Windows drive)
 	if strings.HasPrefix(path, "/mnt/") && len(path) > 5 {
 		drive := strings.ToUpper(string(path[5]))
-		rest := ""
+		rest := "/"
 		if len(path) > 6 {
 			rest = path[6:]
 		}
@@ -35,15 +47,16 @@ func convertToWindowsPath(path string) string {
 	}
 
 	// It's a native WSL path, convert to \\wsl$\ or \\wsl.localhost\
+	winPath := strings.ReplaceAll(path, "/", "\\")
 	distro := getWSLDistro()
 	if distro != "" {
 		// Try \\wsl.localhost\ first (newer), fall back to \\wsl$\
-		wslPath := `\\wsl.localhost\` + distro + path
-		if _, err := os.Stat(wslPath); err == nil {
+		wslPath := `\\wsl.localhost\` + distro + winPath
+		if _, err := statPath(wslPath); err == nil {
 			return wslPath
 		}
-		wslPath = `\\wsl$\` + distro + path
-		if _, err := os.Stat(wslPath); err == nil {
+		wslPath = `\\wsl$\` + distro + winPath
+		if _, err := statPath(wslPath); err == nil {
 			return wslPath
 		}
 	}
@@ -51,12 +64,12 @@ func convertToWindowsPath(path string) string {
 	// Try common distro names
 	distros := []string{"Ubuntu", "Ubuntu-22.04", "Ubuntu-20.04", "Debian", "kali-linux", "openSUSE-Leap-15", "Alpine"}
 	for _, d := range distros {
-		wslPath := `\\wsl.localhost\` + d + path
-		if _, err := os.Stat(wslPath); err == nil {
+		wslPath := `\\wsl.localhost\` + d + winPath
+		if _, err := statPath(wslPath); err == nil {
 			return wslPath
 		}
-		wslPath = `\\wsl$\` + d + path
-		if _, err := os.Stat(wslPath); err == nil {
+		wslPath = `\\wsl$\` + d + winPath
+		if _, err := statPath(wslPath); err == nil {
 			return wslPath
 		}
 	}
@@ -72,8 +85,10 @@ func convertToLinuxPath(path string) string {
 	}
 
 	// Handle \\wsl$\ or \\wsl.localhost\ paths
-	wslPattern := regexp.MustCompile(`^\\\\wsl[\$\.]localhost?\\[^\\]+(.*)$`)
-	if matches := wslPattern.FindStringSubmatch(path); len(matches) > 1 {
+	if matches := wslSharePattern.FindStringSubmatch(path); matches != nil {
+		if matches[1] == "" {
+			return "/"
+		}
 		return strings.ReplaceAll(matches[1], "\\", "/")
 	}
 
@@ -90,8 +105,23 @@ func convertToLinuxPath(path string) string {
 	return path
 }
 
-// getWSLDistro gets the current WSL distro name (when running on Windows)
+var (
+	wslDistroOnce sync.Once
+	wslDistro     string
+)
+
+// getWSLDistro gets the current WSL distro name (when running on Windows).
+// The lookup runs at most once per process: spawning wsl.exe is slow and
+// addFolder may convert hundreds of paths.
 func getWSLDistro() string {
+	wslDistroOnce.Do(func() {
+		wslDistro = lookupWSLDistro()
+	})
+	return wslDistro
+}
+
+// lookupWSLDistro finds the distro name from the environment or wsl.exe
+func lookupWSLDistro() string {
 	// Try to get from environment or wsl command
 	if distro := os.Getenv("WSL_DISTRO_NAME"); distro != "" {
 		return distro
@@ -101,11 +131,9 @@ func getWSLDistro() string {
 	cmd := exec.Command("wsl", "-l", "-q")
 	out, err := cmd.Output()
 	if err == nil {
-		lines := strings.Split(string(out), "\n")
+		lines := strings.Split(decodeWSLOutput(out), "\n")
 		for _, line := range lines {
 			line = strings.TrimSpace(line)
-			// Remove null bytes (UTF-16 artifacts)
-			line = strings.ReplaceAll(line, "\x00", "")
 			if line != "" {
 				return line
 			}
@@ -115,16 +143,103 @@ func getWSLDistro() string {
 	return ""
 }
 
-// NormalizePath normalizes a path for the current OS
+// decodeWSLOutput converts wsl.exe output to a string. wsl.exe writes
+// UTF-16LE, with or without a byte order mark; when WSL_UTF8=1 is set it
+// writes UTF-8 instead.
+func decodeWSLOutput(out []byte) string {
+	switch {
+	case bytes.HasPrefix(out, []byte{0xFF, 0xFE}):
+		return decodeUTF16LE(out[2:])
+	case bytes.HasPrefix(out, []byte{0xEF, 0xBB, 0xBF}):
+		return string(out[3:])
+	case len(out) >= 2 && out[0] != 0 && out[1] == 0:
+		// No BOM, but ASCII text in UTF-16LE has a zero in every odd byte
+		return decodeUTF16LE(out)
+	}
+	return string(out)
+}
+
+// decodeUTF16LE decodes little-endian UTF-16 bytes
+func decodeUTF16LE(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	return string(utf16.Decode(u))
+}
+
+// NormalizePath normalizes a path for the current OS.
+// It expands a leading ~, converts WSL/Windows paths, and resolves symlinks
+// (e.g. /var -> /private/var on macOS) so that different spellings of the
+// same file normalize to the same path.
 func NormalizePath(path string) string {
-	converted := ConvertPath(path)
-	return filepath.Clean(converted)
+	cleaned := CleanPath(path)
+	if isUNCPath(cleaned) {
+		return cleaned
+	}
+	if resolved, err := filepath.EvalSymlinks(cleaned); err == nil {
+		return resolved
+	}
+	return cleaned
+}
+
+// CleanPath is NormalizePath without resolving symlinks, so a symlink keeps
+// its own name. It is used where the name as typed matters. The result is
+// absolute and clean: relative paths are resolved against the working
+// directory, and "." and ".." segments, repeated and trailing separators
+// are removed, so every spelling of a path cleans to the same string.
+func CleanPath(path string) string {
+	// Network shares and \\?\ extended-length paths are already absolute
+	// and must not be rewritten
+	if isUNCPath(path) {
+		return path
+	}
+	return absPath(filepath.Clean(ConvertPath(expandHome(path))))
+}
+
+// absPath makes a clean path absolute, leaving it as it is if the working
+// directory can't be determined.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// isUNCPath reports whether path is a UNC path such as \\server\share\doc.md
+// or an extended-length path (\\?\C:\...). WSL shares (\\wsl$\, \\wsl.localhost\)
+// are excluded since they are converted to native paths on Linux.
+func isUNCPath(path string) bool {
+	if !strings.HasPrefix(path, `\\`) {
+		return false
+	}
+	lower := strings.ToLower(path)
+	return !strings.HasPrefix(lower, `\\wsl$\`) && !strings.HasPrefix(lower, `\\wsl.localhost\`)
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
 }
 
-// NormalizePathForComparison normalizes a path for comparison
-// On Windows, paths are case-insensitive
+// NormalizePathForComparison normalizes a path for comparison: local paths
+// are cleaned and made absolute like CleanPath. On Windows, paths are
+// case-insensitive. Remote URLs and piped documents are compared as they are.
 func NormalizePathForComparison(path string) string {
+	if isRemotePath(path) || isStdinPath(path) {
+		return path
+	}
 	cleaned := filepath.Clean(path)
+	if !isUNCPath(cleaned) {
+		cleaned = absPath(cleaned)
+	}
 	if runtime.GOOS == "windows" {
 		return strings.ToLower(cleaned)
 	}
@@ -137,6 +252,38 @@ func PathsEqual(path1, path2 string) bool {
 	return NormalizePathForComparison(path1) == NormalizePathForComparison(path2)
 }
 
+// SameFile reports whether two paths name the same file. Beyond PathsEqual,
+// paths that differ only in case are compared by identity on disk, since on
+// a case-insensitive filesystem (macOS, /mnt/c under WSL) they can be the
+// same file even on Linux.
+func SameFile(path1, path2 string) bool {
+	if PathsEqual(path1, path2) {
+		return true
+	}
+	if !strings.EqualFold(filepath.Clean(path1), filepath.Clean(path2)) {
+		return false
+	}
+	info1, err := os.Stat(path1)
+	if err != nil {
+		return false
+	}
+	info2, err := os.Stat(path2)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(info1, info2)
+}
+
+// IsWithin reports whether path is root or inside it. Both are compared as
+// given, so callers resolve symlinks first when that matters.
+func IsWithin(root, path string) bool {
+	rel, err := filepath.Rel(NormalizePathForComparison(root), NormalizePathForComparison(path))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
 // FindPathKey finds the actual key used in a map for a given path
 // Returns the key and true if found, empty string and false if not
 func FindPathKey(paths map[string]interface{}, path string) (string, bool) {
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestIsUNCPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{`\\server\share\doc.md`, true},
+		{`\\?\C:\x\doc.md`, true},
+		{`\\wsl$\Ubuntu\home\doc.md`, false},
+		{`\\wsl.localhost\Ubuntu\home\doc.md`, false},
+		{`C:\x\doc.md`, false},
+		{`/home/user/doc.md`, false},
+		{`doc.md`, false},
+	}
+	for _, tt := range tests {
+		if got := isUNCPath(tt.path); got != tt.want {
+			t.Errorf("isUNCPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePathKeepsUNCPaths(t *testing.T) {
+	for _, path := range []string{`\\server\share\doc.md`, `\\?\C:\x\doc.md`} {
+		if got := NormalizePath(path); got != path {
+			t.Errorf("NormalizePath(%q) = %q, want it unchanged", path, got)
+		}
+		if got := CleanPath(path); got != path {
+			t.Errorf("CleanPath(%q) = %q, want it unchanged", path, got)
+		}
+	}
+}
+
+func TestConvertWSLSharePaths(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`\\wsl$\Ubuntu-22.04\home\user\doc.md`, "/home/user/doc.md"},
+		{`\\wsl.localhost\Debian\home\user\doc.md`, "/home/user/doc.md"},
+		{`\\wsl.localhost\openSUSE-Leap-15.5\srv\notes.md`, "/srv/notes.md"},
+		{`\\WSL$\Ubuntu\tmp\a.md`, "/tmp/a.md"},
+		{`\\wsl$\Ubuntu-22.04`, "/"},
+		{`\\wsl$\Ubuntu-22.04\home\user\My Notes\read me.md`, "/home/user/My Notes/read me.md"},
+	}
+	for _, tt := range tests {
+		if got := convertToLinuxPath(tt.path); got != tt.want {
+			t.Errorf("convertToLinuxPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestWSLPathWithSpacesRoundTrips(t *testing.T) {
+	share := `\\wsl.localhost\Ubuntu-22.04\home\user\My Notes\read me.md`
+	// Only the share exists, whatever distros this machine has
+	stat := statPath
+	statPath = func(path string) (os.FileInfo, error) {
+		if path == share {
+			return nil, nil
+		}
+		return nil, os.ErrNotExist
+	}
+	defer func() { statPath = stat }()
+
+	tests := []struct {
+		windows string
+		linux   string
+	}{
+		{share, "/home/user/My Notes/read me.md"},
+		{`C:\Users\Me\My Documents\read me.md`, "/mnt/c/Users/Me/My Documents/read me.md"},
+	}
+	for _, tt := range tests {
+		linux := convertToLinuxPath(tt.windows)
+		if linux != tt.linux {
+			t.Errorf("convertToLinuxPath(%q) = %q, want %q", tt.windows, linux, tt.linux)
+			continue
+		}
+		if back := convertToWindowsPath(linux); back != tt.windows {
+			t.Errorf("convertToWindowsPath(%q) = %q, want %q", linux, back, tt.windows)
+		}
+	}
+}
+
+func TestDecodeWSLOutput(t *testing.T) {
+	utf16le := func(s string) []byte {
+		var b []byte
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = append(b, byte(u), byte(u>>8))
+		}
+		return b
+	}
+	list := "Ubuntu-22.04\r\nDebian\r\nkali-linux\r\n"
+	tests := []struct {
+		name string
+		out  []byte
+	}{
+		{"utf-16 with bom", append([]byte{0xFF, 0xFE}, utf16le(list)...)},
+		{"utf-16 without bom", utf16le(list)},
+		{"utf-8 with bom", append([]byte{0xEF, 0xBB, 0xBF}, list...)},
+		{"utf-8", []byte(list)},
+	}
+	for _, tt := range tests {
+		if got := decodeWSLOutput(tt.out); got != list {
+			t.Errorf("%s: decodeWSLOutput = %q, want %q", tt.name, got, list)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	stdhtml "html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// PlantUML rendering
+//
+// With start --plantuml-url, .puml files and ```plantuml fences in markdown
+// are sent to a PlantUML server, and the SVG it returns is embedded:
+//
+//	livemd start --plantuml-url http://localhost:8080
+//
+// Without a server they are shown as highlighted source.
+
+// plantUMLServer renders PlantUML source through a PlantUML server's
+// POST /svg endpoint. SVGs are cached by source, so unchanged diagrams
+// aren't requested again on every reload.
+type plantUMLServer struct {
+	url    string
+	client *http.Client
+	cache  *renderCache
+}
+
+func newPlantUMLServer(url string) *plantUMLServer {
+	return &plantUMLServer{
+		url:    strings.TrimSuffix(url, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+		cache:  newRenderCache(defaultCacheSize),
+	}
+}
+
+// render returns the SVG of a PlantUML diagram.
+func (p *plantUMLServer) render(source string) (string, error) {
+	key := cacheKey("plantuml", []byte(source))
+	if svg, ok := p.cache.Get(key); ok {
+		return svg, nil
+	}
+
+	resp, err := p.client.Post(p.url+"/svg", "text/plain; charset=utf-8", strings.NewReader(source))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	// PlantUML answers syntax errors with an SVG of the error, which is
+	// worth showing, so only other failures are errors
+	if resp.StatusCode != http.StatusOK && !strings.Contains(resp.Header.Get("Content-Type"), "svg") {
+		return "", fmt.Errorf("PlantUML server returned %d", resp.StatusCode)
+	}
+
+	svg := string(body)
+	p.cache.Put(key, svg)
+	return svg, nil
+}
+
+// isPlantUMLFence reports whether a fenced code block's language is PlantUML.
+func isPlantUMLFence(lang string) bool {
+	lang = strings.ToLower(lang)
+	return lang == "plantuml" || lang == "puml"
+}
+
+// KindPlantUML is the node kind of a PlantUML fenced code block.
+var KindPlantUML = ast.NewNodeKind("PlantUML")
+
+// PlantUMLBlock is a ```plantuml fenced code block, rendered as a diagram.
+type PlantUMLBlock struct {
+	ast.BaseBlock
+	Source string
+}
+
+func (n *PlantUMLBlock) Kind() ast.NodeKind {
+	return KindPlantUML
+}
+
+func (n *PlantUMLBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// plantUMLTransformer replaces PlantUML fenced code blocks with PlantUMLBlock
+// nodes.
+type plantUMLTransformer struct{}
+
+func (t *plantUMLTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var fences []*ast.FencedCodeBlock
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if fcb, ok := n.(*ast.FencedCodeBlock); ok && entering && isPlantUMLFence(string(fcb.Language(source))) {
+			fences = append(fences, fcb)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	// Rewrite after walking so the tree isn't modified mid-walk
+	for _, fcb := range fences {
+		var code strings.Builder
+		lines := fcb.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			seg := lines.At(i)
+			code.Write(seg.Value(source))
+		}
+		block := &PlantUMLBlock{Source: code.String()}
+		fcb.Parent().ReplaceChild(fcb.Parent(), fcb, block)
+	}
+}
+
+// plantUMLRenderer renders PlantUMLBlock nodes as the SVG from the server,
+// or as the escaped source with the error when that fails.
+type plantUMLRenderer struct {
+	server *plantUMLServer
+}
+
+func (r *plantUMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindPlantUML, r.renderPlantUML)
+}
+
+func (r *plantUMLRenderer) renderPlantUML(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*PlantUMLBlock)
+	svg, err := r.server.render(n.Source)
+	if err != nil {
+		fmt.Fprintf(w, "<div class=\"diagram-error\">PlantUML: %s</div>\n<pre><code>%s</code></pre>\n",
+			stdhtml.EscapeString(err.Error()), stdhtml.EscapeString(n.Source))
+		return ast.WalkSkipChildren, nil
+	}
+	fmt.Fprintf(w, "<div class=\"plantuml\">%s</div>\n", svg)
+	return ast.WalkSkipChildren, nil
+}
+
+// plantUML is a goldmark extension rendering PlantUML fences through a
+// PlantUML server.
+type plantUML struct {
+	server *plantUMLServer
+}
+
+func (e *plantUML) Extend(m goldmark.Markdown) {
+	// After fenceAttributeTransformer, so { .plantuml } fences are found too
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&plantUMLTransformer{}, 600),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&plantUMLRenderer{server: e.server}, 500),
+	))
+}
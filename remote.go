@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// remotePollInterval is how often a remote URL is re-fetched for changes,
+// since fsnotify cannot watch it.
+const remotePollInterval = 5 * time.Second
+
+// remoteClient is used for fetching remote files. The timeout keeps a slow
+// host from stalling the poller.
+var remoteClient = &http.Client{Timeout: 15 * time.Second}
+
+// remoteStatusError is returned when a remote URL answers with a non-200 status.
+// Watched URLs in this state are treated as deleted/unavailable.
+type remoteStatusError struct {
+	URL    string
+	Status int
+}
+
+func (e *remoteStatusError) Error() string {
+	return fmt.Sprintf("%s returned %d", e.URL, e.Status)
+}
+
+// remoteTooLargeError is returned when a remote file is over the size
+// limit. Size is -1 when the server didn't say how large it is.
+type remoteTooLargeError struct {
+	URL   string
+	Size  int64
+	Limit int64
+}
+
+func (e *remoteTooLargeError) Error() string {
+	return fmt.Sprintf("%s is larger than %s", e.URL, formatSize(e.Limit))
+}
+
+// isRemotePath reports whether path is an http(s) URL rather than a file on disk.
+func isRemotePath(p string) bool {
+	return strings.HasPrefix(p, "http://") || strings.HasPrefix(p, "https://")
+}
+
+// remoteName returns the file name of a URL, e.g. "README.md" for
+// https://raw.githubusercontent.com/user/repo/main/README.md.
+func remoteName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Path == "" || u.Path == "/" {
+		return rawURL
+	}
+	return path.Base(u.Path)
+}
+
+// fetchRemote downloads a remote file and returns its content and last
+// modification time (from Last-Modified, or now if the server doesn't say).
+// A body over maxSize bytes (0 for no limit) isn't read past the limit; the
+// modification time is returned with a *remoteTooLargeError then.
+func fetchRemote(rawURL string, maxSize int64) ([]byte, time.Time, error) {
+	resp, err := remoteClient.Get(rawURL)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, &remoteStatusError{URL: rawURL, Status: resp.StatusCode}
+	}
+
+	modTime := time.Now()
+	if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		modTime = lm
+	}
+
+	if maxSize <= 0 {
+		content, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return content, modTime, nil
+	}
+	if resp.ContentLength > maxSize {
+		return nil, modTime, &remoteTooLargeError{URL: rawURL, Size: resp.ContentLength, Limit: maxSize}
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, modTime, &remoteTooLargeError{URL: rawURL, Size: -1, Limit: maxSize}
+	}
+	return content, modTime, nil
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 	"unicode/utf8"
 
 	"github.com/alecthomas/chroma/v2"
@@ -12,61 +18,530 @@ import (
 	"github.com/alecthomas/chroma/v2/lexers"
 	"github.com/alecthomas/chroma/v2/styles"
 	"github.com/yuin/goldmark"
+	emoji "github.com/yuin/goldmark-emoji"
 	highlighting "github.com/yuin/goldmark-highlighting/v2"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
 	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
 )
 
-const maxLines = 1000
+// defaultMaxLines is how many lines of a code file are rendered unless
+// overridden with --max-lines.
+const defaultMaxLines = 1000
+
+// defaultMaxFileSize is the largest file rendered unless overridden with
+// --max-file-size. Larger files get a placeholder instead of being read.
+const defaultMaxFileSize = 10 << 20
+
+// defaultStyle is the chroma style used for syntax highlighting.
+const defaultStyle = "github"
+
+// defaultRenderTimeout is how long a render may take unless overridden with
+// --render-timeout.
+const defaultRenderTimeout = 10 * time.Second
+
+// errRenderTimeout is returned, together with a placeholder, when a render
+// takes longer than the configured timeout.
+var errRenderTimeout = errors.New("render timed out")
+
+// defaultHighlightMaxSize is the most code highlighted unless overridden with
+// --highlight-max-size. Tokenizing is the slow part of rendering, so larger
+// code is shown as plain text.
+const defaultHighlightMaxSize = 1 << 20
+
+// streamMinSize is the smallest code file, in bytes, that browsers fetch
+// from /api/render as a stream instead of taking its HTML over the
+// WebSocket.
+const streamMinSize = 1 << 20
+
+// RendererConfig holds the rendering settings chosen at server start.
+type RendererConfig struct {
+	MaxLines    int    `json:"maxLines"`    // lines of a code file to render, 0 for no limit
+	MaxFileSize int64  `json:"maxFileSize"` // bytes; larger files aren't read, 0 for no limit
+	Style       string `json:"style"`       // chroma style for code highlighting
+	HardWraps   bool   `json:"hardWraps"`   // render newlines in paragraphs as <br>
+	Unsafe      bool   `json:"unsafe"`      // pass raw HTML in markdown through
+	NoHighlight bool   `json:"noHighlight"` // show code files as plain text
+	// HighlightMaxSize is the most code (in bytes, after the line limit) that
+	// is highlighted; larger code is shown as plain text. 0 for no limit.
+	HighlightMaxSize int64 `json:"highlightMaxSize"`
+	// RenderTimeout bounds how long rendering one file may take; 0 for no limit.
+	RenderTimeout time.Duration `json:"renderTimeout"`
+	// HTMLPreview shows .html files as the rendered page (scripts allowed,
+	// in a sandboxed iframe) instead of highlighted source. Trusted use only.
+	HTMLPreview bool `json:"htmlPreview"`
+	// PlantUMLURL is a PlantUML server rendering .puml files and plantuml
+	// fences to SVG; empty shows them as source.
+	PlantUMLURL string `json:"plantumlUrl"`
+	// GoFmt shows .go files as gofmt would format them, noting when the
+	// file on disk differs.
+	GoFmt bool `json:"gofmt"`
+	// Includes expands {{include file}} lines in markdown.
+	Includes bool `json:"includes"`
+	// Structured shows data files (JSON, YAML, TOML) as a foldable tree.
+	Structured bool `json:"structured"`
+	// Lexers maps extensions (".gohtml") and file names, lower-cased, to
+	// Chroma lexer names, from the lexers= config entry. getLexer consults
+	// it before its built-in mappings.
+	Lexers map[string]string `json:"lexers,omitempty"`
+	// CollapseOver folds the lines of a code file past this many into a
+	// "Show N more lines" <details>; 0 shows them all.
+	CollapseOver int `json:"collapseOver"`
+}
+
+// DefaultRendererConfig returns the settings used when no flags are given.
+func DefaultRendererConfig() RendererConfig {
+	return RendererConfig{
+		MaxLines:    defaultMaxLines,
+		MaxFileSize: defaultMaxFileSize,
+		Style:       defaultStyle,
+		HardWraps:   true,
+		Unsafe:      true,
+
+		HighlightMaxSize: defaultHighlightMaxSize,
+		RenderTimeout:    defaultRenderTimeout,
+	}
+}
 
 // Renderer converts files to HTML
 type Renderer struct {
-	md goldmark.Markdown
+	md       goldmark.Markdown
+	cache    *renderCache
+	config   RendererConfig
+	plantuml *plantUMLServer // nil without --plantuml-url
+	// formatters rewrite code files before highlighting, by extension
+	formatters map[string]codeFormatter
+
+	includes *includeIndex  // files each markdown file's last render included
+	roots    []string       // --root directories; includes outside them are refused
+	flights  *renderFlights // renders running under the timeout
+
+	// A renderer for one file's options (withOptions) shares the above and
+	// differs in these
+	styled   *styledMarkdown // markdown converters for other highlight styles
+	slides   bool            // render markdown as a slide deck
+	cacheTag string          // keeps renders with other options apart in the cache
+	fresh    bool            // render without looking up the cache (uncached)
 }
 
-func NewRenderer() *Renderer {
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,
-			highlighting.NewHighlighting(
-				highlighting.WithStyle("github"),
-				highlighting.WithFormatOptions(),
-			),
+// NewRenderer builds a renderer for config. The markdown options are fixed
+// once built; changing them means building a new renderer.
+func NewRenderer(config RendererConfig) *Renderer {
+	var plantuml *plantUMLServer
+	if config.PlantUMLURL != "" {
+		plantuml = newPlantUMLServer(config.PlantUMLURL)
+	}
+	return &Renderer{
+		md:         newMarkdown(config, plantuml),
+		cache:      newRenderCache(defaultCacheSize),
+		config:     config,
+		plantuml:   plantuml,
+		formatters: codeFormatters(config),
+		includes:   &includeIndex{byFile: make(map[string][]string)},
+		flights:    &renderFlights{byKey: make(map[string]*renderFlight), overdue: make(map[string]bool)},
+		styled:     &styledMarkdown{byStyle: make(map[string]goldmark.Markdown)},
+	}
+}
+
+// newMarkdown builds the markdown converter for config.
+func newMarkdown(config RendererConfig, plantuml *plantUMLServer) goldmark.Markdown {
+	var htmlOptions []renderer.Option
+	if config.HardWraps {
+		htmlOptions = append(htmlOptions, goldmarkhtml.WithHardWraps())
+	}
+	if config.Unsafe {
+		htmlOptions = append(htmlOptions, goldmarkhtml.WithUnsafe())
+	}
+
+	extensions := []goldmark.Extender{
+		extension.GFM,
+		extension.Footnote,
+		extension.DefinitionList,
+		emoji.Emoji,
+		Admonitions,
+		FenceAttributes,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle(config.Style),
+			highlighting.WithFormatOptions(),
 		),
+	}
+	if plantuml != nil {
+		extensions = append(extensions, &plantUML{server: plantuml})
+	}
+
+	return goldmark.New(
+		goldmark.WithExtensions(extensions...),
 		goldmark.WithParserOptions(
 			parser.WithAutoHeadingID(),
 		),
-		goldmark.WithRendererOptions(
-			goldmarkhtml.WithHardWraps(),
-			goldmarkhtml.WithUnsafe(),
-		),
+		goldmark.WithRendererOptions(htmlOptions...),
 	)
+}
 
-	return &Renderer{md: md}
+// cached returns the cached render for key, unless the renderer is
+// uncached.
+func (r *Renderer) cached(key string) (string, bool) {
+	if r.fresh {
+		return "", false
+	}
+	return r.cache.Get(key)
 }
 
-func (r *Renderer) Render(filepath string) (string, error) {
-	content, err := os.ReadFile(filepath)
+// uncached returns a renderer that renders files from scratch instead of
+// reusing cached renders, for a refresh asked for by the user. The new
+// renders are still cached.
+func (r *Renderer) uncached() *Renderer {
+	c := *r
+	c.fresh = true
+	return &c
+}
+
+func (r *Renderer) Render(path string) (string, error) {
+	info, err := os.Stat(path)
 	if err != nil {
 		return "", err
 	}
+	if html, tooLarge := r.sizeCheck(path, info.Size()); tooLarge {
+		return html, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return r.RenderContent(path, content)
+}
+
+// sizeCheck returns a placeholder when a file of the given size is over the
+// configured limit, and false otherwise. Callers stat the file and check
+// before reading it, so a huge file is never loaded into memory.
+func (r *Renderer) sizeCheck(path string, size int64) (string, bool) {
+	if r.config.MaxFileSize <= 0 || size <= r.config.MaxFileSize {
+		return "", false
+	}
+	return r.tooLarge(path, formatSize(size)), true
+}
+
+// tooLarge is the placeholder for a file over the size limit; size says
+// how large it is.
+func (r *Renderer) tooLarge(path, size string) string {
+	return fmt.Sprintf(`<div style="text-align: center; padding: 40px; color: #666;">
+		<p>File too large: %s (%s)</p>
+		<p style="color: #999; font-size: 14px; margin-top: 8px;">Files over %s are not rendered. Start livemd with a larger --max-file-size to view it.</p>
+	</div>`, filepath.Base(path), size, formatSize(r.config.MaxFileSize))
+}
+
+// RenderContent renders content that has already been read. The path only
+// decides how it is rendered (markdown, code, binary) and need not exist on
+// disk, which is how remote URLs are rendered.
+func (r *Renderer) RenderContent(path string, content []byte) (string, error) {
+	if isMarkdown(path) {
+		content = r.includeMarkdown(path, content)
+	}
+
+	// Identical content renders identically, so reuse earlier output
+	key := cacheKey(r.cacheTag+r.cacheName(path), content)
+	if html, ok := r.cached(key); ok {
+		return html, nil
+	}
+
+	return r.withTimeout(path, key, func() (string, error) {
+		return r.render(path, content)
+	})
+}
+
+// cacheName is the part of a file's cache key that stands for the file: its
+// lowercased name, which decides how it renders, or the whole path for an
+// HTML preview, whose iframe loads the page from that path.
+func (r *Renderer) cacheName(path string) string {
+	if r.htmlPreview(path) {
+		return path
+	}
+	return strings.ToLower(filepath.Base(path))
+}
+
+// renderFlights tracks the renders running under the timeout.
+type renderFlights struct {
+	mu    sync.Mutex
+	byKey map[string]*renderFlight // by cache key
+	// overdue holds the paths with a render still running past the timeout
+	overdue map[string]bool
+}
+
+// renderFlight is one render running under the timeout; done is closed
+// once html and err are set.
+type renderFlight struct {
+	done chan struct{}
+	html string
+	err  error
+}
+
+// withTimeout runs render and caches its output under key, giving up once
+// the configured timeout has passed and returning a placeholder with
+// errRenderTimeout. The render itself can't be interrupted, so renders are
+// kept from piling up: a caller asking for a key already rendering waits
+// for that render instead of starting another, and while a render of path
+// runs past the timeout, new renders of path get the placeholder at once.
+// A render that finishes late is still cached, for the next change to find.
+func (r *Renderer) withTimeout(path, key string, render func() (string, error)) (string, error) {
+	if r.config.RenderTimeout <= 0 {
+		html, err := render()
+		if err == nil {
+			r.cache.Put(key, html)
+		}
+		return html, err
+	}
+
+	fl := r.flights
+	fl.mu.Lock()
+	f, running := fl.byKey[key]
+	if !running {
+		if fl.overdue[path] {
+			fl.mu.Unlock()
+			return r.timeoutPlaceholder(path)
+		}
+		f = &renderFlight{done: make(chan struct{})}
+		fl.byKey[key] = f
+		go func() {
+			f.html, f.err = render()
+			if f.err == nil {
+				r.cache.Put(key, f.html)
+			}
+			fl.mu.Lock()
+			delete(fl.byKey, key)
+			delete(fl.overdue, path)
+			fl.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	fl.mu.Unlock()
+
+	timer := time.NewTimer(r.config.RenderTimeout)
+	defer timer.Stop()
+	select {
+	case <-f.done:
+		return f.html, f.err
+	case <-timer.C:
+		fl.mu.Lock()
+		if fl.byKey[key] == f {
+			fl.overdue[path] = true
+		}
+		fl.mu.Unlock()
+		return r.timeoutPlaceholder(path)
+	}
+}
+
+// timeoutPlaceholder is what withTimeout returns for a render that took too
+// long.
+func (r *Renderer) timeoutPlaceholder(path string) (string, error) {
+	return fmt.Sprintf(`<div style="text-align: center; padding: 40px; color: #666;">
+		<p>Render timed out: %s</p>
+		<p style="color: #999; font-size: 14px; margin-top: 8px;">Rendering took longer than %s. Start livemd with a larger --render-timeout to view it.</p>
+	</div>`, filepath.Base(path), r.config.RenderTimeout), fmt.Errorf("%w after %s", errRenderTimeout, r.config.RenderTimeout)
+}
+
+// RenderTail is like RenderContent, but code is cut to its last lines rather
+// than its first, for following a growing file such as a log. Markdown and
+// binary files render as usual.
+func (r *Renderer) RenderTail(path string, content []byte) (string, error) {
+	if isMarkdown(path) || isRST(path) || isBinary(content) {
+		return r.RenderContent(path, content)
+	}
 
+	key := cacheKey("\x00tail:"+r.cacheTag+strings.ToLower(filepath.Base(path)), content)
+	if html, ok := r.cached(key); ok {
+		return html, nil
+	}
+
+	return r.withTimeout(path, key, func() (string, error) {
+		return r.renderTail(path, content), nil
+	})
+}
+
+func (r *Renderer) renderTail(path string, content []byte) string {
+	// The newest lines stay in view, so nothing is collapsed
+	if r.config.CollapseOver > 0 {
+		t := *r
+		t.config.CollapseOver = 0
+		r = &t
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(normalizeNewlines(content)), "\n"), "\n")
+	firstLine := 1
+	var buf bytes.Buffer
+	if r.config.MaxLines > 0 && len(lines) > r.config.MaxLines {
+		firstLine = len(lines) - r.config.MaxLines + 1
+		lines = lines[firstLine-1:]
+		buf.WriteString(tailNotice(r.config.MaxLines))
+	}
+	code := strings.Join(lines, "\n")
+	if err := r.writeCode(&buf, path, code, false, firstLine, nil); err != nil {
+		return r.renderPlainText(code, false)
+	}
+	return buf.String()
+}
+
+func (r *Renderer) render(path string, content []byte) (string, error) {
 	// Check if binary
 	if isBinary(content) {
-		return renderBinaryMessage(filepath), nil
+		return renderBinaryMessage(path), nil
 	}
 
-	// Check if markdown
-	if isMarkdown(filepath) {
-		return r.renderMarkdown(content)
+	if render := r.documentRenderer(path); render != nil {
+		return render(content)
 	}
 
-	// Render as code with syntax highlighting
-	return r.renderCode(filepath, content)
+	// Render as code with syntax highlighting, formatted first if a
+	// formatter is enabled for it
+	content, note := r.formatCode(path, content)
+	html, err := r.renderCode(path, content)
+	return note + html, err
+}
+
+// documentRenderer returns how a file that isn't shown as code is rendered,
+// chosen by its path, or nil for code.
+func (r *Renderer) documentRenderer(path string) func(content []byte) (string, error) {
+	if isMarkdown(path) {
+		return r.renderMarkdown
+	}
+	if isRST(path) {
+		return r.renderRST
+	}
+	// Diagram sources are drawn by the browser
+	if kind := diagramKind(path); kind != "" {
+		return func(content []byte) (string, error) {
+			return r.renderDiagram(path, kind, content), nil
+		}
+	}
+	if r.htmlPreview(path) {
+		return func(content []byte) (string, error) {
+			return r.renderHTMLPreview(path, content), nil
+		}
+	}
+	if p, ok := r.structuredView(path); ok {
+		return func(content []byte) (string, error) {
+			return r.renderStructured(path, p, content)
+		}
+	}
+	return nil
+}
+
+// streams reports whether a local file is shown by streaming it with
+// RenderTo rather than keeping its render: large code files, whose
+// highlighted HTML would take several times their size in memory.
+func (r *Renderer) streams(path string, content []byte) bool {
+	return len(content) >= streamMinSize && !isBinary(content) && r.documentRenderer(path) == nil
+}
+
+// RenderTo renders a file straight into w. Code files are read only up to
+// the line limit and the highlighted HTML is written out as it is produced,
+// so a large file is never held in memory as one formatted string.
+// Lines in hl (inclusive [start, end] ranges) are highlighted in code files.
+// Other files render as with RenderContent, and are written out whole; a
+// render that times out writes its placeholder. Streamed code is not
+// cached.
+func (r *Renderer) RenderTo(w io.Writer, path string, hl [][2]int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if html, tooLarge := r.sizeCheck(path, info.Size()); tooLarge {
+		_, err = io.WriteString(w, html)
+		return err
+	}
+
+	if r.documentRenderer(path) != nil {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		html, err := r.RenderContent(path, content)
+		if err != nil && !errors.Is(err, errRenderTimeout) {
+			return err
+		}
+		_, err = io.WriteString(w, html)
+		return err
+	}
+
+	var reader *bufio.Reader
+	if _, ok := r.formatter(path); ok {
+		// Formatting needs the whole file
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if isBinary(content) {
+			_, err = io.WriteString(w, renderBinaryMessage(path))
+			return err
+		}
+		content, note := r.formatCode(path, content)
+		if _, err := io.WriteString(w, note); err != nil {
+			return err
+		}
+		reader = bufio.NewReader(bytes.NewReader(content))
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = bufio.NewReader(f)
+	}
+
+	sample, _ := reader.Peek(8000)
+	if isBinary(sample) {
+		_, err = io.WriteString(w, renderBinaryMessage(path))
+		return err
+	}
+
+	code, truncated, err := readLines(reader, r.config.MaxLines)
+	if err != nil {
+		return err
+	}
+	code = string(normalizeNewlines([]byte(code)))
+	return r.writeCode(w, path, code, truncated, 1, hl)
+}
+
+// normalizeNewlines converts CRLF and lone CR line endings to LF, so files
+// written on Windows (or with mixed endings) split into lines cleanly and
+// don't leave stray carriage returns in the output.
+func normalizeNewlines(b []byte) []byte {
+	if bytes.IndexByte(b, '\r') < 0 {
+		return b
+	}
+	b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(b, []byte("\r"), []byte("\n"))
+}
+
+// readLines reads up to max lines from reader (all of it when max is 0) and
+// reports whether more content remained.
+func readLines(reader *bufio.Reader, max int) (string, bool, error) {
+	var sb strings.Builder
+	for n := 0; max <= 0 || n < max; n++ {
+		line, err := reader.ReadString('\n')
+		sb.WriteString(line)
+		if err == io.EOF {
+			return sb.String(), false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+	}
+	_, err := reader.Peek(1)
+	return strings.TrimSuffix(sb.String(), "\n"), err == nil, nil
 }
 
 func (r *Renderer) renderMarkdown(content []byte) (string, error) {
+	if source, ok := slidesSource(content); ok {
+		return r.renderSlides(source)
+	}
+	if r.slides {
+		if _, body, ok := splitFrontMatter(content); ok {
+			content = body
+		}
+		return r.renderSlides(content)
+	}
 	var buf bytes.Buffer
 	if err := r.md.Convert(content, &buf); err != nil {
 		return "", err
@@ -76,78 +551,161 @@ func (r *Renderer) renderMarkdown(content []byte) (string, error) {
 
 func (r *Renderer) renderCode(path string, content []byte) (string, error) {
 	// Limit lines
-	lines := strings.Split(string(content), "\n")
+	lines := strings.Split(string(normalizeNewlines(content)), "\n")
 	truncated := false
-	if len(lines) > maxLines {
-		lines = lines[:maxLines]
+	if r.config.MaxLines > 0 && len(lines) > r.config.MaxLines {
+		lines = lines[:r.config.MaxLines]
 		truncated = true
 	}
 	code := strings.Join(lines, "\n")
 
+	var buf bytes.Buffer
+	if err := r.writeCode(&buf, path, code, truncated, 1, nil); err != nil {
+		return r.renderPlainText(code, truncated), nil
+	}
+	return buf.String(), nil
+}
+
+// writeCode highlights code and writes the HTML to w, followed by a
+// truncation notice when the file was cut at the line limit. Lines are
+// numbered from firstLine, and the line ranges in hl get a highlighted
+// background.
+func (r *Renderer) writeCode(w io.Writer, path, code string, truncated bool, firstLine int, hl [][2]int) error {
+	if !r.highlights(code) {
+		_, err := io.WriteString(w, r.renderPlainText(code, truncated))
+		return err
+	}
+
 	// Get lexer
-	lexer := getLexer(path)
+	lexer := getLexer(path, r.config.Lexers)
 	if lexer == nil {
 		lexer = lexers.Fallback
 	}
 	lexer = chroma.Coalesce(lexer)
 
 	// Get style and formatter
-	style := styles.Get("github")
+	style := styles.Get(r.config.Style)
 	if style == nil {
 		style = styles.Fallback
 	}
-	formatter := html.New(
-		html.WithClasses(false),
-		html.WithLineNumbers(true),
-		html.TabWidth(4),
-	)
+	formatter := func(base int) *html.Formatter {
+		return html.New(
+			html.WithClasses(false),
+			html.WithLineNumbers(true),
+			html.WithLinkableLineNumbers(true, "L"),
+			html.TabWidth(4),
+			html.BaseLineNumber(base),
+			html.HighlightLines(hl),
+		)
+	}
 
 	// Tokenize and format
 	iterator, err := lexer.Tokenise(nil, code)
 	if err != nil {
 		// Fall back to plain text
-		return renderPlainText(code, truncated), nil
+		_, err = io.WriteString(w, r.renderPlainText(code, truncated))
+		return err
 	}
 
-	var buf bytes.Buffer
-	err = formatter.Format(&buf, style, iterator)
-	if err != nil {
-		return renderPlainText(code, truncated), nil
+	lines := chroma.SplitTokensIntoLines(iterator.Tokens())
+	shown := len(lines)
+	if r.config.CollapseOver > 0 && shown > r.config.CollapseOver {
+		shown = r.config.CollapseOver
+	}
+	if err := formatter(firstLine).Format(w, style, chroma.Literator(joinTokenLines(lines[:shown])...)); err != nil {
+		return err
+	}
+	if shown < len(lines) {
+		if _, err := io.WriteString(w, collapseSummary(len(lines)-shown)); err != nil {
+			return err
+		}
+		if err := formatter(firstLine+shown).Format(w, style, chroma.Literator(joinTokenLines(lines[shown:])...)); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "</details>"); err != nil {
+			return err
+		}
 	}
 
-	result := buf.String()
 	if truncated {
-		result += `<div style="padding: 12px; background: #fff3cd; color: #856404; border-radius: 4px; margin-top: 16px;">
-			Showing first 1000 lines. File has more content.
-		</div>`
+		_, err = io.WriteString(w, truncationNotice(r.config.MaxLines))
 	}
+	return err
+}
+
+// joinTokenLines flattens lines of tokens back into one stream.
+func joinTokenLines(lines [][]chroma.Token) []chroma.Token {
+	var tokens []chroma.Token
+	for _, line := range lines {
+		tokens = append(tokens, line...)
+	}
+	return tokens
+}
 
-	return result, nil
+// collapseSummary opens the <details> holding the lines of code past
+// --collapse-over, folded until clicked.
+func collapseSummary(hidden int) string {
+	unit := "lines"
+	if hidden == 1 {
+		unit = "line"
+	}
+	return fmt.Sprintf(`<details class="code-collapse"><summary>Show %d more %s</summary>`, hidden, unit)
 }
 
-func renderPlainText(code string, truncated bool) string {
+// highlights reports whether code is syntax highlighted, which is skipped
+// with --no-highlight or when the code is over --highlight-max-size.
+func (r *Renderer) highlights(code string) bool {
+	if r.config.NoHighlight {
+		return false
+	}
+	return r.config.HighlightMaxSize <= 0 || int64(len(code)) <= r.config.HighlightMaxSize
+}
+
+func (r *Renderer) renderPlainText(code string, truncated bool) string {
+	const pre = `<pre style="background: #f6f8fa; padding: 16px; overflow-x: auto; border-radius: 6px; font-family: monospace; font-size: 14px; line-height: 1.45;"><code>`
 	escaped := strings.ReplaceAll(code, "&", "&amp;")
 	escaped = strings.ReplaceAll(escaped, "<", "&lt;")
 	escaped = strings.ReplaceAll(escaped, ">", "&gt;")
 
-	result := `<pre style="background: #f6f8fa; padding: 16px; overflow-x: auto; border-radius: 6px; font-family: monospace; font-size: 14px; line-height: 1.45;"><code>` + escaped + `</code></pre>`
+	result := pre + escaped + `</code></pre>`
+	if r.config.CollapseOver > 0 {
+		lines := strings.SplitAfter(escaped, "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		if len(lines) > r.config.CollapseOver {
+			result = pre + strings.Join(lines[:r.config.CollapseOver], "") + `</code></pre>` +
+				collapseSummary(len(lines)-r.config.CollapseOver) +
+				pre + strings.Join(lines[r.config.CollapseOver:], "") + `</code></pre></details>`
+		}
+	}
 
 	if truncated {
-		result += `<div style="padding: 12px; background: #fff3cd; color: #856404; border-radius: 4px; margin-top: 16px;">
-			Showing first 1000 lines. File has more content.
-		</div>`
+		result += truncationNotice(r.config.MaxLines)
 	}
 
 	return result
 }
 
+// truncationNotice is appended to code cut off at the line limit.
+func truncationNotice(maxLines int) string {
+	return fmt.Sprintf(`<div style="padding: 12px; background: #fff3cd; color: #856404; border-radius: 4px; margin-top: 16px;">
+			Showing first %d lines. File has more content.
+		</div>`, maxLines)
+}
+
+// tailNotice is shown above code cut to its last lines in tail mode.
+func tailNotice(maxLines int) string {
+	return fmt.Sprintf(`<div style="padding: 12px; background: #fff3cd; color: #856404; border-radius: 4px; margin-bottom: 16px;">
+			Showing last %d lines. Earlier content is hidden.
+		</div>`, maxLines)
+}
+
 func renderBinaryMessage(path string) string {
-	ext := strings.ToLower(filepath.Ext(path))
 	name := filepath.Base(path)
 
 	// Check if it's an image
-	imageExts := map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true, ".webp": true, ".ico": true}
-	if imageExts[ext] {
+	if isImage(path) {
 		return `<div style="text-align: center; padding: 40px;">
 			<p style="color: #666; margin-bottom: 16px;">Image file: ` + name + `</p>
 			<p style="color: #999; font-size: 14px;">Image preview not supported</p>
@@ -161,6 +719,36 @@ func renderBinaryMessage(path string) string {
 	</div>`
 }
 
+// File kinds reported to the frontend, which picks icons and styling by them
+const (
+	kindMarkdown = "markdown"
+	kindCode     = "code"
+	kindImage    = "image"
+	kindBinary   = "binary"
+)
+
+// fileKind returns the kind of a file from its name and content. content
+// may be nil when the file wasn't read (e.g. too large).
+func fileKind(path string, content []byte) string {
+	switch {
+	case isImage(path):
+		return kindImage
+	case isBinary(content):
+		return kindBinary
+	case isMarkdown(path), isRST(path):
+		return kindMarkdown
+	}
+	return kindCode
+}
+
+func isImage(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".ico":
+		return true
+	}
+	return false
+}
+
 func isMarkdown(path string) bool {
 	ext := strings.ToLower(filepath.Ext(path))
 	return ext == ".md" || ext == ".markdown" || ext == ".mdown" || ext == ".mkd"
@@ -188,40 +776,106 @@ func isBinary(content []byte) bool {
 	return false
 }
 
-func getLexer(path string) chroma.Lexer {
+// lexerFiles maps special filenames (lowercased) to a Chroma lexer name.
+var lexerFiles = map[string]string{
+	"makefile":         "makefile",
+	"gnumakefile":      "makefile",
+	"dockerfile":       "docker",
+	".gitignore":       "gitignore",
+	".gitattributes":   "gitignore",
+	".gitmodules":      "gitignore",
+	".dockerignore":    "docker",
+	".editorconfig":    "ini",
+	".env":             "bash",
+	".bashrc":          "bash",
+	".zshrc":           "bash",
+	".bash_profile":    "bash",
+	"cmakelists.txt":   "cmake",
+	"go.mod":           "gomod",
+	"go.sum":           "gomod",
+	"cargo.toml":       "toml",
+	"cargo.lock":       "toml",
+	"package.json":     "json",
+	"tsconfig.json":    "json",
+	"composer.json":    "json",
+	"requirements.txt": "text",
+	"gemfile":          "ruby",
+	"rakefile":         "ruby",
+	"vagrantfile":      "ruby",
+	"jenkinsfile":      "groovy",
+}
+
+// lexerExtensions maps file extensions, without the dot, to a Chroma lexer
+// name where the extension alone doesn't find the right lexer.
+var lexerExtensions = map[string]string{
+	"yml":    "yaml",
+	"js":     "javascript",
+	"ts":     "typescript",
+	"tsx":    "typescript",
+	"jsx":    "javascript",
+	"py":     "python",
+	"rb":     "ruby",
+	"rs":     "rust",
+	"sh":     "bash",
+	"zsh":    "bash",
+	"fish":   "fish",
+	"ps1":    "powershell",
+	"psm1":   "powershell",
+	"bat":    "batch",
+	"cmd":    "batch",
+	"h":      "c",
+	"hpp":    "cpp",
+	"cc":     "cpp",
+	"cxx":    "cpp",
+	"cs":     "csharp",
+	"fs":     "fsharp",
+	"kt":     "kotlin",
+	"kts":    "kotlin",
+	"scala":  "scala",
+	"clj":    "clojure",
+	"ex":     "elixir",
+	"exs":    "elixir",
+	"erl":    "erlang",
+	"hrl":    "erlang",
+	"hs":     "haskell",
+	"ml":     "ocaml",
+	"mli":    "ocaml",
+	"pl":     "perl",
+	"pm":     "perl",
+	"r":      "r",
+	"lua":    "lua",
+	"vim":    "vim",
+	"el":     "emacs-lisp",
+	"lisp":   "common-lisp",
+	"scm":    "scheme",
+	"rkt":    "racket",
+	"asm":    "nasm",
+	"s":      "gas",
+	"tf":     "terraform",
+	"hcl":    "hcl",
+	"nix":    "nix",
+	"vue":    "vue",
+	"svelte": "svelte",
+}
+
+func getLexer(path string, custom map[string]string) chroma.Lexer {
 	name := strings.ToLower(filepath.Base(path))
 	ext := strings.ToLower(filepath.Ext(path))
 
+	// Mappings from the config file come first
+	if lexerName, ok := custom[name]; ok {
+		if l := lexers.Get(lexerName); l != nil {
+			return l
+		}
+	}
+	if lexerName, ok := custom[ext]; ok && ext != "" {
+		if l := lexers.Get(lexerName); l != nil {
+			return l
+		}
+	}
+
 	// Special filenames
-	specialFiles := map[string]string{
-		"makefile":      "makefile",
-		"gnumakefile":   "makefile",
-		"dockerfile":    "docker",
-		".gitignore":    "gitignore",
-		".gitattributes": "gitignore",
-		".gitmodules":   "gitignore",
-		".dockerignore": "docker",
-		".editorconfig": "ini",
-		".env":          "bash",
-		".bashrc":       "bash",
-		".zshrc":        "bash",
-		".bash_profile": "bash",
-		"cmakelists.txt": "cmake",
-		"go.mod":        "gomod",
-		"go.sum":        "gomod",
-		"cargo.toml":    "toml",
-		"cargo.lock":    "toml",
-		"package.json":  "json",
-		"tsconfig.json": "json",
-		"composer.json": "json",
-		"requirements.txt": "text",
-		"gemfile":       "ruby",
-		"rakefile":      "ruby",
-		"vagrantfile":   "ruby",
-		"jenkinsfile":   "groovy",
-	}
-
-	if lexerName, ok := specialFiles[name]; ok {
+	if lexerName, ok := lexerFiles[name]; ok {
 		if l := lexers.Get(lexerName); l != nil {
 			return l
 		}
@@ -233,58 +887,7 @@ func getLexer(path string) chroma.Lexer {
 		extNoDot := ext[1:]
 
 		// Common extension mappings
-		extMap := map[string]string{
-			"yml":  "yaml",
-			"js":   "javascript",
-			"ts":   "typescript",
-			"tsx":  "typescript",
-			"jsx":  "javascript",
-			"py":   "python",
-			"rb":   "ruby",
-			"rs":   "rust",
-			"sh":   "bash",
-			"zsh":  "bash",
-			"fish": "fish",
-			"ps1":  "powershell",
-			"psm1": "powershell",
-			"bat":  "batch",
-			"cmd":  "batch",
-			"h":    "c",
-			"hpp":  "cpp",
-			"cc":   "cpp",
-			"cxx":  "cpp",
-			"cs":   "csharp",
-			"fs":   "fsharp",
-			"kt":   "kotlin",
-			"kts":  "kotlin",
-			"scala": "scala",
-			"clj":  "clojure",
-			"ex":   "elixir",
-			"exs":  "elixir",
-			"erl":  "erlang",
-			"hrl":  "erlang",
-			"hs":   "haskell",
-			"ml":   "ocaml",
-			"mli":  "ocaml",
-			"pl":   "perl",
-			"pm":   "perl",
-			"r":    "r",
-			"lua":  "lua",
-			"vim":  "vim",
-			"el":   "emacs-lisp",
-			"lisp": "common-lisp",
-			"scm":  "scheme",
-			"rkt":  "racket",
-			"asm":  "nasm",
-			"s":    "gas",
-			"tf":   "terraform",
-			"hcl":  "hcl",
-			"nix":  "nix",
-			"vue":  "vue",
-			"svelte": "svelte",
-		}
-
-		if mappedName, ok := extMap[extNoDot]; ok {
+		if mappedName, ok := lexerExtensions[extNoDot]; ok {
 			if l := lexers.Get(mappedName); l != nil {
 				return l
 			}
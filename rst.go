@@ -0,0 +1,764 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	stdhtml "html"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// reStructuredText
+//
+// .rst files render as documents. There is no reStructuredText library
+// among this module's dependencies, so a file is translated to markdown
+// and rendered like one, code highlighting included. The translation covers
+// what most READMEs and docs use:
+//
+//   - section titles, underlined or over- and underlined, leveled in the
+//     order their adornment styles first appear
+//   - paragraphs, bullet, enumerated, definition and field lists, block
+//     quotes, line blocks and transitions
+//   - literal blocks (after "::"), doctest blocks and the code-block, code
+//     and sourcecode directives
+//   - admonitions (note, warning, ...), images and figures
+//   - inline literals, emphasis, strong, roles, hyperlinks and hyperlink
+//     targets
+//
+// Tables and other directives are shown as their source, with a note
+// above the document naming what wasn't rendered.
+
+// isRST reports whether path is a reStructuredText file.
+func isRST(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".rst"
+}
+
+// renderRST renders reStructuredText through the markdown converter.
+func (r *Renderer) renderRST(content []byte) (string, error) {
+	markdown, skipped := rstToMarkdown(content)
+	var buf bytes.Buffer
+	if len(skipped) > 0 {
+		buf.WriteString(rstNotice(skipped))
+	}
+	if err := r.md.Convert(markdown, &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// rstNotice is shown above a document with parts shown as source.
+func rstNotice(skipped []string) string {
+	return fmt.Sprintf(`<div class="format-notice" style="padding: 12px; background: #e7f3fe; color: #0c5460; border-radius: 4px; margin-bottom: 16px;">
+			livemd renders a subset of reStructuredText. Shown as source: %s.
+		</div>`, stdhtml.EscapeString(strings.Join(skipped, ", ")))
+}
+
+// rstConverter translates one reStructuredText document.
+type rstConverter struct {
+	targets map[string]string // hyperlink targets by normalized name
+	styles  []string          // title adornment styles, in order of appearance
+	skipped []string          // constructs shown as source
+}
+
+// rstToMarkdown translates reStructuredText to markdown. It also returns
+// the constructs it could only show as source.
+func rstToMarkdown(content []byte) ([]byte, []string) {
+	text := strings.ReplaceAll(string(normalizeNewlines(content)), "\t", "        ")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	c := &rstConverter{targets: rstTargets(lines)}
+	return []byte(strings.Join(c.blocks(lines), "\n") + "\n"), c.skipped
+}
+
+var (
+	rstBullet     = regexp.MustCompile(`^([-*+•‣⁃]) +`)
+	rstEnumerated = regexp.MustCompile(`^(?:(\d+|#)[.)]|\((\d+|#)\)) +`)
+	rstField      = regexp.MustCompile(`^:([^:\s][^:]*):(?: +|$)`)
+	rstDirective  = regexp.MustCompile(`^([\w:+.-]+)::(?: +(.*))?$`)
+	rstTarget     = regexp.MustCompile("^\\.\\. _(`[^`]+`|[^:]+):(?: +(.*))?$")
+	rstSimpleRule = regexp.MustCompile(`^=+( +=+)+$`)
+)
+
+// rstAdmonitions maps admonition directives to the markdown alert markers.
+var rstAdmonitions = map[string]string{
+	"note": "NOTE", "hint": "NOTE", "seealso": "NOTE",
+	"tip":       "TIP",
+	"important": "IMPORTANT",
+	"warning":   "WARNING", "attention": "WARNING",
+	"caution": "CAUTION", "danger": "CAUTION", "error": "CAUTION",
+}
+
+// rstIgnored are directives that have nothing to show.
+var rstIgnored = map[string]bool{
+	"contents": true, "index": true, "meta": true, "highlight": true,
+	"sectnum": true, "default-role": true, "currentmodule": true,
+}
+
+// blocks translates lines whose least indented lines start at column 0.
+func (c *rstConverter) blocks(lines []string) []string {
+	var out []string
+	emit := func(block ...string) {
+		if len(block) == 0 {
+			return
+		}
+		if len(out) > 0 {
+			out = append(out, "")
+		}
+		out = append(out, block...)
+	}
+
+	literal := false
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if line == "" {
+			i++
+			continue
+		}
+		afterLiteral := literal
+		literal = false
+
+		// Indented text: a literal block after "::", a block quote otherwise
+		if indentOf(line) > 0 {
+			block, next := indentedBlock(lines, i)
+			if afterLiteral {
+				emit(fence("", block)...)
+			} else {
+				emit(quote(c.blocks(block), "")...)
+			}
+			i = next
+			continue
+		}
+
+		next := ""
+		if i+1 < len(lines) {
+			next = lines[i+1]
+		}
+
+		switch {
+		case isAdornment(line) && i+2 < len(lines) && next != "" && lines[i+2] == line:
+			emit(c.title("o"+line[:1], next))
+			i += 3
+			continue
+		case isAdornment(line) && len(line) >= 4 && (i == 0 || lines[i-1] == "") && next == "":
+			emit("---")
+			i++
+			continue
+		case isAdornment(next) && utf8.RuneCountInString(next) >= utf8.RuneCountInString(line) && !isAdornment(line):
+			emit(c.title(next[:1], line))
+			i += 2
+			continue
+		case line == ".." || strings.HasPrefix(line, ".. "):
+			body, end := indentedBlock(lines, i+1)
+			emit(c.explicit(line, body)...)
+			i = end
+			continue
+		case strings.HasPrefix(line, ">>>"):
+			end := i
+			for end < len(lines) && lines[end] != "" {
+				end++
+			}
+			emit(fence("python", lines[i:end])...)
+			i = end
+			continue
+		case line == "|" || strings.HasPrefix(line, "| "):
+			end := c.lineBlock(lines, i, emit)
+			i = end
+			continue
+		case strings.HasPrefix(line, "+-") || strings.HasPrefix(line, "+="):
+			end := i
+			for end < len(lines) && lines[end] != "" {
+				end++
+			}
+			c.skip("tables")
+			emit(fence("text", lines[i:end])...)
+			i = end
+			continue
+		case rstSimpleRule.MatchString(line):
+			end := i + 1
+			for end < len(lines) {
+				end++
+				if rstSimpleRule.MatchString(lines[end-1]) && (end == len(lines) || lines[end] == "") {
+					break
+				}
+			}
+			c.skip("tables")
+			emit(fence("text", lines[i:end])...)
+			i = end
+			continue
+		}
+
+		if m := rstBullet.FindStringSubmatch(line); m != nil {
+			var items []string
+			for i < len(lines) && strings.HasPrefix(lines[i], m[0][:len(m[1])]) && rstBullet.MatchString(lines[i]) {
+				var body []string
+				body, i = listItem(lines, i, len(rstBullet.FindString(lines[i])))
+				items = append(items, listLines("- ", c.blocks(body))...)
+				i = skipBlank(lines, i)
+			}
+			emit(items...)
+			continue
+		}
+		if m := rstEnumerated.FindStringSubmatch(line); m != nil {
+			start := m[1] + m[2]
+			if start == "#" {
+				start = "1"
+			}
+			var items []string
+			for i < len(lines) && rstEnumerated.MatchString(lines[i]) {
+				var body []string
+				body, i = listItem(lines, i, len(rstEnumerated.FindString(lines[i])))
+				marker := "1. "
+				if items == nil {
+					marker = start + ". "
+				}
+				items = append(items, listLines(marker, c.blocks(body))...)
+				i = skipBlank(lines, i)
+			}
+			emit(items...)
+			continue
+		}
+		if rstField.MatchString(line) {
+			var fields []string
+			for i < len(lines) && rstField.MatchString(lines[i]) {
+				m := rstField.FindString(lines[i])
+				name := strings.Trim(strings.TrimSpace(m), ":")
+				body, end := indentedBlock(lines, i+1)
+				first := "**" + name + ":** " + strings.TrimSpace(lines[i][len(m):])
+				fields = append(fields, listLines("- ", c.blocks(append([]string{first}, body...)))...)
+				i = skipBlank(lines, end)
+			}
+			emit(fields...)
+			continue
+		}
+
+		// A line followed by indented lines is a definition list item
+		if next != "" && indentOf(next) > 0 {
+			var items []string
+			for i+1 < len(lines) && lines[i] != "" && indentOf(lines[i]) == 0 && lines[i+1] != "" && indentOf(lines[i+1]) > 0 {
+				body, end := indentedBlock(lines, i+1)
+				items = append(items, c.inline(lines[i]))
+				items = append(items, listLines(": ", c.blocks(body))...)
+				items = append(items, "")
+				i = skipBlank(lines, end)
+			}
+			emit(items[:len(items)-1]...)
+			continue
+		}
+
+		// Paragraph
+		end := i
+		for end < len(lines) && lines[end] != "" && indentOf(lines[end]) == 0 {
+			end++
+		}
+		text := strings.Join(lines[i:end], "\n")
+		i = end
+		if strings.HasSuffix(text, "::") {
+			literal = true
+			switch {
+			case text == "::":
+				continue
+			case strings.HasSuffix(text, " ::") || strings.HasSuffix(text, "\n::"):
+				text = strings.TrimRightFunc(strings.TrimSuffix(text, "::"), unicode.IsSpace)
+			default:
+				text = strings.TrimSuffix(text, ":")
+			}
+		}
+		emit(paragraph(c.inline(text))...)
+	}
+	return out
+}
+
+// title returns the markdown heading for a section title whose adornment
+// has the given style.
+func (c *rstConverter) title(style, text string) string {
+	level := 0
+	for level < len(c.styles) && c.styles[level] != style {
+		level++
+	}
+	if level == len(c.styles) {
+		c.styles = append(c.styles, style)
+	}
+	if level > 5 {
+		level = 5
+	}
+	return strings.Repeat("#", level+1) + " " + c.inline(strings.TrimSpace(text))
+}
+
+// explicit translates an explicit markup block: a directive, a hyperlink
+// target, a footnote or a comment.
+func (c *rstConverter) explicit(first string, body []string) []string {
+	rest := strings.TrimSpace(strings.TrimPrefix(first, ".."))
+	switch {
+	case strings.HasPrefix(rest, "_"), strings.HasPrefix(rest, "|"):
+		// Targets are resolved up front, substitutions aren't supported
+		return nil
+	case strings.HasPrefix(rest, "["):
+		if end := strings.Index(rest, "]"); end > 0 {
+			label := "[" + rest[1:end] + "]"
+			return c.blocks(append([]string{"\\" + label + " " + strings.TrimSpace(rest[end+1:])}, body...))
+		}
+		return nil
+	}
+
+	m := rstDirective.FindStringSubmatch(rest)
+	if m == nil {
+		return nil // a comment
+	}
+	name, arg := strings.ToLower(m[1]), strings.TrimSpace(m[2])
+	source := append([]string{first}, prefixLines("   ", "", body)...)
+	options := map[string]string{}
+	for len(body) > 0 {
+		f := rstField.FindString(body[0])
+		if f == "" {
+			break
+		}
+		options[strings.Trim(strings.TrimSpace(f), ":")] = strings.TrimSpace(body[0][len(f):])
+		body = body[1:]
+	}
+
+	switch {
+	case name == "code-block" || name == "code" || name == "sourcecode":
+		return fence(arg, trimBlank(body))
+	case name == "math":
+		return fence("latex", trimBlank(append([]string{arg}, body...)))
+	case name == "image" || name == "figure":
+		image := "![" + rstEscape(options["alt"]) + "](" + linkDestination(arg) + ")"
+		return append([]string{image}, prefixBlocks(c.blocks(body))...)
+	case name == "admonition":
+		return quote(c.blocks(body), "**"+c.inline(arg)+"**")
+	case rstAdmonitions[name] != "":
+		content := append(strings.Split(arg, "\n"), body...)
+		return quote(append([]string{"[!" + rstAdmonitions[name] + "]"}, c.blocks(trimBlank(content))...), "")
+	case name == "rubric":
+		return []string{"**" + c.inline(arg) + "**"}
+	case name == "topic" || name == "sidebar":
+		return append([]string{"**" + c.inline(arg) + "**", ""}, c.blocks(body)...)
+	case name == "container" || name == "rst-class" || name == "class" || name == "compound":
+		return c.blocks(body)
+	case rstIgnored[name]:
+		return nil
+	}
+
+	c.skip(".. " + name + "::")
+	return fence("text", source)
+}
+
+// lineBlock emits the line block starting at lines[i], keeping its line
+// breaks, and returns the index after it.
+func (c *rstConverter) lineBlock(lines []string, i int, emit func(...string)) int {
+	var text []string
+	for i < len(lines) && (lines[i] == "|" || strings.HasPrefix(lines[i], "| ") || (lines[i] != "" && indentOf(lines[i]) > 0 && len(text) > 0)) {
+		line := lines[i]
+		if line == "|" || strings.HasPrefix(line, "| ") {
+			text = append(text, strings.TrimSpace(strings.TrimPrefix(line, "|")))
+		} else {
+			text[len(text)-1] += " " + strings.TrimSpace(line)
+		}
+		i++
+	}
+	for j, line := range text {
+		line = escapeLineStart(c.inline(line))
+		if j < len(text)-1 {
+			line += "\\"
+		}
+		text[j] = line
+	}
+	emit(text...)
+	return i
+}
+
+// skip records a construct shown as source.
+func (c *rstConverter) skip(what string) {
+	for _, s := range c.skipped {
+		if s == what {
+			return
+		}
+	}
+	c.skipped = append(c.skipped, what)
+}
+
+// rstTargets collects the named hyperlink targets of a document.
+func rstTargets(lines []string) map[string]string {
+	targets := make(map[string]string)
+	for i, line := range lines {
+		m := rstTarget.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		url := m[2]
+		for j := i + 1; j < len(lines) && lines[j] != "" && indentOf(lines[j]) > 0; j++ {
+			url += strings.TrimSpace(lines[j])
+		}
+		url = strings.Join(strings.Fields(url), "")
+		if url != "" {
+			targets[rstRefName(m[1])] = url
+		}
+	}
+	// Targets may point at other targets ("name_")
+	for name, url := range targets {
+		if alias, ok := targets[rstRefName(strings.TrimSuffix(url, "_"))]; ok && strings.HasSuffix(url, "_") {
+			targets[name] = alias
+		}
+	}
+	return targets
+}
+
+// rstRefName normalizes a reference name for lookup.
+func rstRefName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.Trim(name, "`")), " "))
+}
+
+// isAdornment reports whether line is a section title adornment: a run of
+// one punctuation character.
+func isAdornment(line string) bool {
+	if len(line) < 2 || !strings.ContainsRune("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", rune(line[0])) {
+		return false
+	}
+	return strings.Count(line, line[:1]) == len(line)
+}
+
+// indentOf returns the number of leading spaces of line.
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// indentedBlock returns the indented lines starting at lines[i], dedented,
+// and the index after them. Blank lines inside the block are kept.
+func indentedBlock(lines []string, i int) ([]string, int) {
+	end := i
+	for end < len(lines) && (lines[end] == "" || indentOf(lines[end]) > 0) {
+		end++
+	}
+	block := trimBlank(lines[i:end])
+	for end > i && lines[end-1] == "" {
+		end--
+	}
+	return dedent(block, -1), end
+}
+
+// listItem returns the body of the list item at lines[i], whose text starts
+// at column width, and the index after it.
+func listItem(lines []string, i, width int) ([]string, int) {
+	body := []string{strings.Repeat(" ", width) + lines[i][width:]}
+	rest, end := indentedBlock(lines, i+1)
+	if len(rest) > 0 {
+		body = append(body, lines[i+1:end]...)
+	}
+	return dedent(body, width), end
+}
+
+// dedent removes width leading spaces from every line, or the common
+// indentation when width is negative.
+func dedent(lines []string, width int) []string {
+	if width < 0 {
+		for _, line := range lines {
+			if line != "" && (width < 0 || indentOf(line) < width) {
+				width = indentOf(line)
+			}
+		}
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		if n := indentOf(line); n < width {
+			out[i] = line[n:]
+		} else {
+			out[i] = line[width:]
+		}
+	}
+	return out
+}
+
+// trimBlank drops leading and trailing blank lines.
+func trimBlank(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// skipBlank returns the index of the first non-blank line from i.
+func skipBlank(lines []string, i int) int {
+	for i < len(lines) && lines[i] == "" {
+		i++
+	}
+	return i
+}
+
+// fence returns lines as a fenced code block in lang.
+func fence(lang string, lines []string) []string {
+	marker := "```"
+	for _, line := range lines {
+		for strings.HasPrefix(strings.TrimSpace(line), marker) {
+			marker += "`"
+		}
+	}
+	out := append([]string{marker + lang}, lines...)
+	return append(out, marker)
+}
+
+// quote returns markdown lines as a block quote, led by title if it isn't
+// empty.
+func quote(lines []string, title string) []string {
+	if title != "" {
+		lines = append([]string{title, ""}, lines...)
+	}
+	return prefixLines("> ", ">", lines)
+}
+
+// listLines returns markdown lines as a list item with marker; the lines
+// after the first are indented to the item's content.
+func listLines(marker string, lines []string) []string {
+	if len(lines) == 0 {
+		return []string{strings.TrimSpace(marker)}
+	}
+	out := []string{marker + lines[0]}
+	return append(out, prefixLines(strings.Repeat(" ", len(marker)), "", lines[1:])...)
+}
+
+// prefixLines prefixes each line, using blank for empty lines.
+func prefixLines(prefix, blank string, lines []string) []string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		if line == "" {
+			out[i] = blank
+		} else {
+			out[i] = prefix + line
+		}
+	}
+	return out
+}
+
+// prefixBlocks separates markdown blocks from what comes before them.
+func prefixBlocks(lines []string) []string {
+	if len(lines) == 0 {
+		return nil
+	}
+	return append([]string{""}, lines...)
+}
+
+// paragraph returns translated paragraph text as one line that markdown
+// won't read as the start of another block. Joining the lines keeps
+// hard-wrapped markdown rendering from breaking them.
+func paragraph(text string) []string {
+	return []string{escapeLineStart(strings.ReplaceAll(text, "\n", " "))}
+}
+
+// lineStartPattern matches the start of a line that markdown would read as
+// a heading, list item, quote or thematic break.
+var lineStartPattern = regexp.MustCompile(`^(?:[-+=]|\d+[.)])`)
+
+// escapeLineStart escapes markdown block syntax at the start of line.
+func escapeLineStart(line string) string {
+	if m := lineStartPattern.FindString(line); m != "" {
+		return m[:len(m)-1] + "\\" + line[len(m)-1:]
+	}
+	return line
+}
+
+// rstEscaped are the characters escaped in text so markdown shows them as
+// they are.
+const rstEscaped = "\\`*_[]<>&|~:#!$"
+
+// rstEscape escapes text for markdown.
+func rstEscape(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if strings.IndexByte(rstEscaped, text[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(text[i])
+	}
+	return b.String()
+}
+
+// linkDestination writes url as a markdown link destination.
+func linkDestination(url string) string {
+	if strings.ContainsAny(url, " ()<>") {
+		return "<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(url) + ">"
+	}
+	return url
+}
+
+var (
+	rstRole      = regexp.MustCompile("^:([A-Za-z][\\w.+:-]*):`")
+	rstURL       = regexp.MustCompile("^(?:https?|ftp|mailto):[^\\s<>`\"]+")
+	rstSimpleRef = regexp.MustCompile(`^\w(?:[\w.+-]*\w)?__?`)
+	rstEmbedded  = regexp.MustCompile(`(?s)^(.*?)\s*<([^<>]+)>$`)
+)
+
+// inline translates the inline markup of text.
+func (c *rstConverter) inline(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		if rstStartOK(text, i) {
+			if md, n := c.markup(text, i); n > 0 {
+				b.WriteString(md)
+				i += n
+				continue
+			}
+		}
+		ch := text[i]
+		if ch == '\\' && i+1 < len(text) {
+			// An escaped character is literal; an escaped space is removed
+			i++
+			ch = text[i]
+			if ch == ' ' {
+				i++
+				continue
+			}
+		}
+		if strings.IndexByte(rstEscaped, ch) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(ch)
+		i++
+	}
+	return b.String()
+}
+
+// markup translates the inline markup starting at text[i]. It returns the
+// markdown and the length of the markup, or 0 when there is none.
+func (c *rstConverter) markup(text string, i int) (string, int) {
+	s := text[i:]
+	switch {
+	case strings.HasPrefix(s, "``"):
+		if end := rstEnd(s, "``", 2); end > 0 {
+			return codeSpan(s[2:end]), end + 2
+		}
+	case strings.HasPrefix(s, "**"):
+		if end := rstEnd(s, "**", 2); end > 0 {
+			return "**" + rstEscape(s[2:end]) + "**", end + 2
+		}
+	case strings.HasPrefix(s, "*"):
+		if end := rstEnd(s, "*", 1); end > 0 {
+			return "*" + rstEscape(s[1:end]) + "*", end + 1
+		}
+	case strings.HasPrefix(s, "`"):
+		for _, suffix := range []string{"`__", "`_"} {
+			if end := rstEnd(s, suffix, 1); end > 0 {
+				return c.reference(s[1:end]), end + len(suffix)
+			}
+		}
+		if end := rstEnd(s, "`", 1); end > 0 {
+			return "*" + rstEscape(s[1:end]) + "*", end + 1
+		}
+	case strings.HasPrefix(s, "_`"):
+		if end := rstEnd(s, "`", 2); end > 0 {
+			return rstEscape(s[2:end]), end + 1
+		}
+	case strings.HasPrefix(s, ":"):
+		if m := rstRole.FindString(s); m != "" {
+			if end := rstEnd(s, "`", len(m)); end > 0 {
+				return rstRoleText(strings.Trim(m, ":`"), s[len(m):end]), end + 1
+			}
+		}
+	}
+
+	if m := rstURL.FindString(s); m != "" {
+		m = strings.TrimRight(m, ".,;:!?)'")
+		return "<" + m + ">", len(m)
+	}
+	if m := rstSimpleRef.FindString(s); m != "" && rstEndOK(s, len(m)) {
+		name := strings.TrimRight(m, "_")
+		if url, ok := c.targets[rstRefName(name)]; ok {
+			return "[" + rstEscape(name) + "](" + linkDestination(url) + ")", len(m)
+		}
+	}
+	return "", 0
+}
+
+// reference translates a hyperlink reference: `text <url>`_, `<url>`_ or
+// `name`_.
+func (c *rstConverter) reference(ref string) string {
+	text, url := ref, ""
+	if m := rstEmbedded.FindStringSubmatch(ref); m != nil {
+		text, url = m[1], strings.Join(strings.Fields(m[2]), "")
+		if text == "" {
+			text = url
+		}
+		if strings.HasSuffix(url, "_") {
+			url = c.targets[rstRefName(strings.TrimSuffix(url, "_"))]
+		}
+	} else {
+		url = c.targets[rstRefName(ref)]
+	}
+	if url == "" {
+		return rstEscape(text)
+	}
+	return "[" + rstEscape(text) + "](" + linkDestination(url) + ")"
+}
+
+// rstRoleText translates interpreted text with an explicit role.
+func rstRoleText(role, text string) string {
+	switch role {
+	case "emphasis":
+		return "*" + rstEscape(text) + "*"
+	case "strong":
+		return "**" + rstEscape(text) + "**"
+	case "sub", "sup", "subscript", "superscript", "title-reference", "t":
+		return rstEscape(text)
+	case "ref", "doc", "term", "any":
+		if m := rstEmbedded.FindStringSubmatch(text); m != nil && m[1] != "" {
+			return rstEscape(m[1])
+		}
+		return rstEscape(text)
+	}
+	// Code-like roles (literal, code, file, func, class, ...)
+	if m := rstEmbedded.FindStringSubmatch(text); m != nil && m[1] != "" {
+		text = m[1]
+	}
+	return codeSpan(strings.TrimLeft(text, "~!"))
+}
+
+// codeSpan returns text as a markdown code span.
+func codeSpan(text string) string {
+	text = strings.ReplaceAll(text, "\n", " ")
+	marker := "`"
+	for strings.Contains(text, marker) {
+		marker += "`"
+	}
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") {
+		text = " " + text + " "
+	}
+	return marker + text + marker
+}
+
+// rstEnd returns the index in s of the end-string closing inline markup
+// whose text starts at from, or 0 when it isn't closed.
+func rstEnd(s, end string, from int) int {
+	if from >= len(s) || unicode.IsSpace(rune(s[from])) {
+		return 0
+	}
+	for k := from + 1; k+len(end) <= len(s); k++ {
+		if strings.HasPrefix(s[k:], end) && !unicode.IsSpace(rune(s[k-1])) && s[k-1] != '\\' && rstEndOK(s, k+len(end)) {
+			return k
+		}
+	}
+	return 0
+}
+
+// rstStartOK reports whether inline markup may start at text[i].
+func rstStartOK(text string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRuneInString(text[:i])
+	return unicode.IsSpace(prev) || strings.ContainsRune("-:/'\"<([{", prev)
+}
+
+// rstEndOK reports whether inline markup may end before text[i].
+func rstEndOK(text string, i int) bool {
+	if i >= len(text) {
+		return true
+	}
+	next, _ := utf8.DecodeRuneInString(text[i:])
+	return unicode.IsSpace(next) || strings.ContainsRune("-.,:;!?\\/'\")]}>", next)
+}
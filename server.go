@@ -1,22 +1,31 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/alecthomas/chroma/v2/lexers"
 	"github.com/gorilla/websocket"
 )
 
@@ -26,12 +35,79 @@ var staticFiles embed.FS
 // WatchedFile represents a file being watched
 type WatchedFile struct {
 	Path       string    `json:"path"`
+	LinkPath   string    `json:"linkPath,omitempty"` // symlink it was added through, if any
 	Name       string    `json:"name"`
+	Label      string    `json:"label,omitempty"` // custom display name, shown instead of Name
 	TrackTime  time.Time `json:"trackTime"`
 	LastChange time.Time `json:"lastChange"`
 	HTML       string    `json:"html,omitempty"`
 	Active     bool      `json:"active"`  // true if actively being watched by fsnotify
 	Deleted    bool      `json:"deleted"` // true if file was deleted from disk
+	Size       int64     `json:"size"`    // size in bytes
+	Lines      int       `json:"lines"`   // line count, 0 for binary files
+	Tail       bool      `json:"tail"`    // show the last lines and follow the end, like tail -f
+	Muted      bool      `json:"muted"`   // changes update the list but never take over browsers' view
+	Kind       string    `json:"kind"`    // markdown, code, image or binary
+	// RenderError is set when the last render failed; HTML then holds the
+	// last successful render. Cleared on the next successful render.
+	RenderError string `json:"renderError,omitempty"`
+	// WatchError is set when the file couldn't be watched (e.g. the
+	// inotify limits were reached); the file is then inactive
+	WatchError string `json:"watchError,omitempty"`
+	// Options render this file unlike the server's settings; nil for none
+	Options *FileOptions `json:"options,omitempty"`
+	// Stalled is set when the file's watcher stopped delivering events
+	// unexpectedly; the file is then inactive until activated again
+	Stalled bool `json:"stalled"`
+	// PermissionDenied is set when the watched file can no longer be read;
+	// HTML then holds the last render. Cleared once it reads again.
+	PermissionDenied bool `json:"permissionDenied"`
+	// Streamed is set for a large code file whose HTML isn't kept or sent;
+	// browsers fetch it from /api/render, which streams it
+	Streamed bool `json:"streamed"`
+	// Source is the raw markdown, sent only with start --with-source so
+	// browsers can show it next to the rendered output
+	Source string `json:"source,omitempty"`
+
+	// Revision counts the renders of the file; a partial update names the
+	// revision it applies to
+	Revision int `json:"revision"`
+	// Status is statusPending while the file renders, then statusReady or
+	// statusError. It isn't saved with the state.
+	Status string `json:"status"`
+
+	hash string // content hash of the last render, to skip no-op saves
+	// adding is set while AddFileWithActive renders the file for the
+	// first time; activating it meanwhile only sets Active, and the
+	// watcher starts once that render is stored
+	adding bool
+	// blocks are the top-level blocks of the HTML last sent as an update,
+	// of revision blocksRevision (start --partial-updates)
+	blocks         []string
+	blocksRevision int
+}
+
+// Render statuses of a watched file (WatchedFile.Status)
+const (
+	statusPending = "pending" // being rendered
+	statusReady   = "ready"
+	statusError   = "error" // the last render failed, or the file can't be read
+)
+
+// settleStatus sets f.Status from the outcome of its last render.
+func (f *WatchedFile) settleStatus() {
+	if f.RenderError != "" || f.PermissionDenied {
+		f.Status = statusError
+	} else {
+		f.Status = statusReady
+	}
+}
+
+// renderFailed records why f couldn't be rendered; its HTML is left as the
+// last successful render.
+func (f *WatchedFile) renderFailed(err error) {
+	f.RenderError = err.Error()
+	f.Status = statusError
 }
 
 // Message sent to clients via WebSocket
@@ -42,6 +118,9 @@ type Message struct {
 	Path  string        `json:"path,omitempty"`
 	Log   *LogEntry     `json:"log,omitempty"`
 	Logs  []LogEntry    `json:"logs,omitempty"`
+	// Patch replaces part of File's HTML, which is then left out
+	// (start --partial-updates)
+	Patch *HTMLPatch `json:"patch,omitempty"`
 }
 
 // Client represents a connected WebSocket client
@@ -49,34 +128,230 @@ type Client struct {
 	hub  *Hub
 	conn *websocket.Conn
 	send chan []byte
+	// closeCode is sent in the close frame once send is closed; set by the
+	// hub before closing send
+	closeCode int
+
+	// overflow holds the messages broadcast while send was full, oldest
+	// first, until the writer has drained send
+	mu       sync.Mutex
+	overflow []queuedMessage
+}
+
+// queuedMessage is a broadcast message. While messages wait for a slow
+// client, a message replaces the waiting one with the same key: a file list
+// the previous list, an update the previous update of the same file.
+type queuedMessage struct {
+	data []byte
+	key  string // empty for messages that never supersede others
+	// droppable messages (log entries) may be lost to keep a slow client
+	droppable bool
+}
+
+// maxClientOverflow bounds the messages waiting for a client beyond its
+// send buffer. When it is reached the oldest log entry is dropped, and a
+// client with none waiting is disconnected.
+const maxClientOverflow = 256
+
+// queue hands m to the client's writer. When send is full, m waits in the
+// overflow instead, superseding a waiting message with its key. It returns
+// false when the client is too far behind to keep.
+func (c *Client) queue(m queuedMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.overflow) == 0 {
+		select {
+		case c.send <- m.data:
+			return true
+		default:
+		}
+	}
+
+	if m.key != "" {
+		for i, q := range c.overflow {
+			if q.key == m.key {
+				c.overflow = append(c.overflow[:i], c.overflow[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(c.overflow) >= maxClientOverflow {
+		dropped := false
+		for i, q := range c.overflow {
+			if q.droppable {
+				c.overflow = append(c.overflow[:i], c.overflow[i+1:]...)
+				dropped = true
+				break
+			}
+		}
+		if !dropped {
+			return false
+		}
+	}
+	c.overflow = append(c.overflow, m)
+	return true
+}
+
+// takeOverflow empties the overflow and returns what it held.
+func (c *Client) takeOverflow() []queuedMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	waiting := c.overflow
+	c.overflow = nil
+	return waiting
+}
+
+// heartbeatInterval is how often each WebSocket gets a "heartbeat"
+// message. Browsers treat a connection silent for much longer as lost, and
+// a write to a dead connection fails, so each side notices a dropped peer.
+const heartbeatInterval = 15 * time.Second
+
+// heartbeatMessage is the message sent every heartbeatInterval.
+var heartbeatMessage = []byte(`{"type":"heartbeat"}`)
+
+// clientCloseTimeout bounds how long shutdown waits for close frames to be
+// written to the browsers.
+const clientCloseTimeout = time.Second
+
+// ServerConfig holds the options chosen on the 'livemd start' command line
+type ServerConfig struct {
+	Port     int            `json:"port"`
+	Bind     string         `json:"bind"` // address to listen on; empty for all interfaces
+	Renderer RendererConfig `json:"renderer"`
+	// Extensions overrides the extensions "add -r" picks up (set with
+	// --exts). Empty means the CLI uses its own config and defaults.
+	Extensions []string `json:"extensions"`
+	LogJSON    bool     `json:"logJson"`  // also write log entries to stdout as JSON lines
+	LogLevel   string   `json:"logLevel"` // least severe level kept: info, warn or error
+	// LogStderr also writes warnings and errors to stderr as text lines;
+	// with Verbose, info entries too (connections, changes, ...)
+	LogStderr bool   `json:"logStderr"`
+	Verbose   bool   `json:"verbose"`
+	AllowOpen bool   `json:"allowOpen"` // enable the open-editor and reveal endpoints
+	CustomCSS string `json:"-"`         // user stylesheet from start --css, served at /custom.css
+	// SingleWatcher watches all local files through one SharedWatcher
+	// instead of an fsnotify watcher per file
+	SingleWatcher bool `json:"singleWatcher"`
+	CheckUpdates  bool `json:"checkUpdates"` // look for a newer release in the background at startup
+	WithSource    bool `json:"withSource"`   // send the raw markdown of files along with the HTML
+	// PartialUpdates sends only the changed blocks of large documents
+	PartialUpdates bool `json:"partialUpdates"`
+	// RefreshInterval re-checks the modification time of active files this
+	// often, as a backstop for missed watcher events; 0 disables it
+	RefreshInterval time.Duration `json:"refreshInterval"`
+	// AllowOrigins lists the browser origins allowed besides localhost
+	// (--allow-origin). Empty allows this machine's LAN addresses.
+	AllowOrigins []string `json:"allowOrigins"`
+	// Roots limits the files that can be watched to these directories
+	// (--root). Empty allows any file.
+	Roots []string `json:"roots"`
+	// OnListening is called once the port is bound, before any request is
+	// served. An error stops the server.
+	OnListening func() error `json:"-"`
+	// NewWatcher creates the watchers of files and includes; nil uses
+	// NewWatcher. Tests set it to inject a fake.
+	NewWatcher func() FileWatcher `json:"-"`
 }
 
 // Hub manages files, watchers, and WebSocket clients
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan queuedMessage
 	register   chan *Client
 	unregister chan *Client
 
-	mu       sync.RWMutex
-	files    map[string]*WatchedFile
-	watchers map[string]*Watcher
-	renderer *Renderer
-	logger   *Logger
+	mu         sync.RWMutex
+	files      map[string]*WatchedFile
+	watchers   map[string]FileWatcher
+	newWatcher func() FileWatcher // ServerConfig.NewWatcher
+	shared     *SharedWatcher     // nil unless --single-watcher
+	// withSource keeps the raw markdown in WatchedFile.Source
+	withSource bool
+	// partialUpdates sends changed blocks instead of whole documents
+	partialUpdates bool
+	renderer       *Renderer
+	logger         *Logger
+	selected       string   // file last chosen through /api/select
+	roots          []string // --root directories, symlinks resolved; empty allows any file
+
+	// includeWatchers watch the files included by active markdown files
+	// (start --includes), to re-render the files including them
+	includeMu       sync.Mutex
+	includeWatchers map[string]FileWatcher
+
+	// reloads holds the files being reloaded after a change, with the
+	// reload to run next when another change came in meanwhile (nil for
+	// none). Guarded by mu.
+	reloads map[string]reloadFunc
+
+	listMu      sync.Mutex
+	listPending bool // a file list broadcast is scheduled
+
+	// closeClients asks Run to close every connection with a close frame;
+	// writers tracks the goroutines writing to connections, to wait for the
+	// frames to go out
+	closeClients chan struct{}
+	writers      sync.WaitGroup
+	// stop is closed by Close, ending pollActive
+	stop chan struct{}
+
+	// stdin holds the content of piped documents ("livemd preview")
+	stdinMu sync.Mutex
+	stdin   map[string]stdinDoc
 }
 
-func NewHub() *Hub {
+// fileListDelay is how long a file list broadcast is held back, so that a
+// burst of changes (e.g. a recursive add) goes out as a single list.
+const fileListDelay = 100 * time.Millisecond
+
+func NewHub(config ServerConfig) *Hub {
 	h := &Hub{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 256),
+		broadcast:  make(chan queuedMessage, 256),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		files:      make(map[string]*WatchedFile),
-		watchers:   make(map[string]*Watcher),
-		renderer:   NewRenderer(),
-		logger:     NewLogger(100),
+		watchers:   make(map[string]FileWatcher),
+		renderer:   NewRenderer(config.Renderer),
+
+		includeWatchers: make(map[string]FileWatcher),
+		reloads:         make(map[string]reloadFunc),
+		stdin:           make(map[string]stdinDoc),
+		logger:          NewLogger(100),
+		withSource:      config.WithSource,
+		roots:           normalizeRoots(config.Roots),
+		partialUpdates:  config.PartialUpdates,
+		closeClients:    make(chan struct{}),
+		stop:            make(chan struct{}),
+	}
+	// Includes are read by the renderer, so it must keep to the roots too
+	h.renderer.roots = h.roots
+	h.newWatcher = config.NewWatcher
+	if h.newWatcher == nil {
+		h.newWatcher = func() FileWatcher {
+			w := NewWatcher()
+			w.maxRemoteSize = config.Renderer.MaxFileSize
+			return w
+		}
 	}
 	h.logger.SetHub(h)
+	h.logger.SetLevel(config.LogLevel)
+	if config.LogJSON {
+		h.logger.SetJSONOutput(os.Stdout)
+	}
+	if config.Verbose {
+		h.logger.SetTextOutput(os.Stderr, "info")
+	} else if config.LogStderr {
+		h.logger.SetTextOutput(os.Stderr, "warn")
+	}
+	if config.SingleWatcher {
+		shared, err := NewSharedWatcher()
+		if err != nil {
+			h.logger.Warn(fmt.Sprintf("Can't create shared watcher, watching files one by one: %v", err))
+		} else {
+			h.shared = shared
+		}
+	}
 	return h
 }
 
@@ -98,26 +373,27 @@ func (h *Hub) Run() {
 
 		case message := <-h.broadcast:
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
+				if !client.queue(message) {
+					// Too slow to keep up; the browser reconnects and
+					// gets the current state
+					client.closeCode = websocket.CloseTryAgainLater
 					close(client.send)
 					delete(h.clients, client)
 				}
 			}
+
+		case <-h.closeClients:
+			for client := range h.clients {
+				client.closeCode = websocket.CloseGoingAway
+				close(client.send)
+				delete(h.clients, client)
+			}
 		}
 	}
 }
 
 func (h *Hub) sendFileList(client *Client) {
-	h.mu.RLock()
-	files := make([]WatchedFile, 0, len(h.files))
-	for _, f := range h.files {
-		files = append(files, *f)
-	}
-	h.mu.RUnlock()
-
-	msg := Message{Type: "files", Files: files}
+	msg := Message{Type: "files", Files: h.fileList()}
 	data, _ := json.Marshal(msg)
 	client.send <- data
 
@@ -128,29 +404,80 @@ func (h *Hub) sendFileList(client *Client) {
 	client.send <- logsData
 }
 
-func (h *Hub) broadcastFileList() {
+// fileList returns the files for a list message. Only the file selected
+// through /api/select keeps its HTML and source: browsers get the rest from
+// updates, or fetch a file when they show it (GET /api/files?path=), so the
+// list stays small however many files are registered.
+func (h *Hub) fileList() []WatchedFile {
 	h.mu.RLock()
+	defer h.mu.RUnlock()
 	files := make([]WatchedFile, 0, len(h.files))
-	for _, f := range h.files {
-		files = append(files, *f)
+	for path, f := range h.files {
+		file := *f
+		if path != h.selected {
+			file.HTML = ""
+			file.Source = ""
+		}
+		files = append(files, file)
 	}
-	h.mu.RUnlock()
+	return files
+}
+
+// broadcastFileList schedules a file list broadcast. Calls made within
+// fileListDelay of each other result in one broadcast.
+func (h *Hub) broadcastFileList() {
+	h.listMu.Lock()
+	defer h.listMu.Unlock()
+	if h.listPending {
+		return // the scheduled broadcast will include this change
+	}
+	h.listPending = true
+	time.AfterFunc(fileListDelay, h.flushFileList)
+}
+
+// flushFileList broadcasts the current file list to all clients.
+func (h *Hub) flushFileList() {
+	h.listMu.Lock()
+	h.listPending = false
+	h.listMu.Unlock()
 
-	msg := Message{Type: "files", Files: files}
+	msg := Message{Type: "files", Files: h.fileList()}
 	data, _ := json.Marshal(msg)
-	h.broadcast <- data
+	h.broadcast <- queuedMessage{data: data, key: "files"}
 }
 
+// broadcastFileUpdate sends a file's new render to the clients. The file is
+// encoded after the hub lock is released, so it must be a copy rather than
+// the entry in h.files.
 func (h *Hub) broadcastFileUpdate(file *WatchedFile) {
 	msg := Message{Type: "update", File: file}
+	if h.partialUpdates {
+		msg = h.partialUpdate(file)
+	}
+	data, _ := json.Marshal(msg)
+	h.broadcast <- queuedMessage{data: data, key: "update:" + file.Path}
+}
+
+// broadcastFileTouch tells clients a file was saved without changes. The
+// file is sent without HTML; clients only take its new LastChange.
+func (h *Hub) broadcastFileTouch(file *WatchedFile) {
+	msg := Message{Type: "touch", File: file}
 	data, _ := json.Marshal(msg)
-	h.broadcast <- data
+	h.broadcast <- queuedMessage{data: data, key: "touch:" + file.Path}
+}
+
+// broadcastFileStatus tells clients a file's render status changed. The
+// file is sent without HTML; clients only take its Status.
+func (h *Hub) broadcastFileStatus(file *WatchedFile) {
+	msg := Message{Type: "status", File: file}
+	data, _ := json.Marshal(msg)
+	h.broadcast <- queuedMessage{data: data, key: "status:" + file.Path}
 }
 
 func (h *Hub) broadcastLog(entry LogEntry) {
 	msg := Message{Type: "log", Log: &entry}
 	data, _ := json.Marshal(msg)
-	h.broadcast <- data
+	h.broadcast <- queuedMessage{data: data, droppable: true}
 }
 
 func (h *Hub) AddFile(path string) error {
@@ -158,48 +485,92 @@ func (h *Hub) AddFile(path string) error {
 }
 
 func (h *Hub) AddFileWithActive(path string, active bool) error {
+	// A symlink is watched and rendered through its target, which is what
+	// changes on disk, but keeps the link's name for display
+	var linkPath string
+	if !isRemotePath(path) && !isStdinPath(path) {
+		linkPath = CleanPath(path)
+	}
+	path = normalizeWatchPath(path)
+	if linkPath == path {
+		linkPath = ""
+	}
+	// The resolved path is checked, so a symlink can't lead outside the roots
+	if err := h.checkRoots(path); err != nil {
+		return err
+	}
 	h.mu.Lock()
 
-	// Check if already registered (case-insensitive on Windows)
+	// Check if already registered. Symlinks are already resolved, so this
+	// only has to catch differently-cased names on case-insensitive filesystems
 	for existingPath := range h.files {
-		if PathsEqual(existingPath, path) {
+		if SameFile(existingPath, path) {
 			h.mu.Unlock()
-			return fmt.Errorf("already registered: %s", filepath.Base(existingPath))
+			return fmt.Errorf("%w: %s", errAlreadyRegistered, filepath.Base(existingPath))
 		}
 	}
 
-	// Get file info
-	info, err := os.Stat(path)
-	if err != nil {
-		h.mu.Unlock()
-		return err
+	name := filepath.Base(path)
+	if isRemotePath(path) {
+		name = remoteName(path)
+	} else if isStdinPath(path) {
+		name = strings.TrimPrefix(path, stdinPrefix)
+	} else if linkPath != "" {
+		name = filepath.Base(linkPath)
+	}
+
+	// Registered as pending first, so the file shows up while it renders
+	// and a slow render doesn't hold the lock
+	tail := isTailFile(path)
+	file := &WatchedFile{
+		Path:      path,
+		LinkPath:  linkPath,
+		Name:      name,
+		TrackTime: time.Now(),
+		Active:    active,
+		Tail:      tail,
+		Status:    statusPending,
+		adding:    true,
 	}
+	h.files[path] = file
+	h.mu.Unlock()
+	h.broadcastFileList()
+
+	loaded, err := h.loadFile(path, tail, nil)
 
-	// Render content
-	html, err := h.renderer.Render(path)
+	h.mu.Lock()
+	if h.files[path] != file {
+		// Removed while it rendered
+		h.mu.Unlock()
+		return nil
+	}
 	if err != nil {
+		delete(h.files, path)
+		if w, exists := h.watchers[path]; exists {
+			w.Close()
+			delete(h.watchers, path)
+		}
 		h.mu.Unlock()
+		h.broadcastFileList()
 		return err
 	}
+	loaded.apply(file)
+	file.adding = false
+	// Activated or deactivated while it rendered
+	active = file.Active
+	h.mu.Unlock()
 
-	file := &WatchedFile{
-		Path:       path,
-		Name:       filepath.Base(path),
-		TrackTime:  time.Now(),
-		LastChange: info.ModTime(),
-		HTML:       html,
-		Active:     active,
+	if loaded.renderError != "" {
+		h.logger.Error(fmt.Sprintf("Error rendering %s: %s", name, loaded.renderError))
 	}
-	h.files[path] = file
-
-	h.mu.Unlock()
 
 	// Only start watcher if active
 	if active {
-		h.startWatcher(path)
-		h.logger.Info(fmt.Sprintf("Started watching: %s", filepath.Base(path)))
+		if h.startWatcher(path) == nil {
+			h.logger.Info(fmt.Sprintf("Started watching: %s", name))
+		}
 	} else {
-		h.logger.Info(fmt.Sprintf("Registered: %s", filepath.Base(path)))
+		h.logger.Info(fmt.Sprintf("Registered: %s", name))
 	}
 
 	h.broadcastFileList()
@@ -207,46 +578,236 @@ func (h *Hub) AddFileWithActive(path string, active bool) error {
 	return nil
 }
 
-func (h *Hub) startWatcher(path string) {
+// loadedFile is the rendered HTML and metadata of a watched path.
+type loadedFile struct {
+	html    string
+	modTime time.Time
+	size    int64
+	lines   int
+	hash    string // empty when the content wasn't read (too large)
+	kind    string
+	source  string // raw markdown, with --with-source
+	// streamed is set when the file wasn't rendered, for browsers to
+	// fetch from /api/render
+	streamed bool
+	// renderError is set when html is a placeholder for a render that
+	// timed out
+	renderError string
+}
+
+// apply stores the loaded content in f and replaces any previous render
+// error.
+func (l loadedFile) apply(f *WatchedFile) {
+	f.HTML = l.html
+	f.LastChange = l.modTime
+	f.Size = l.size
+	f.Lines = l.lines
+	f.RenderError = l.renderError
+	f.hash = l.hash
+	f.Kind = l.kind
+	f.Source = l.source
+	f.Streamed = l.streamed
+	f.PermissionDenied = false
+	f.Revision++
+	f.settleStatus()
+}
+
+// unchanged reports whether the loaded content is what f already shows, as
+// when an editor saves a file without modifying it.
+func (l loadedFile) unchanged(f *WatchedFile) bool {
+	return l.hash != "" && l.hash == f.hash && f.RenderError == "" && !f.Deleted && !f.PermissionDenied
+}
+
+// isTailFile reports whether a file starts in tail mode, which is the case
+// for logs.
+func isTailFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".log")
+}
+
+// loadFile reads and renders a watched path, returning the HTML along with
+// the file's modification time, size and line count. Remote URLs are fetched,
+// piped documents come from memory.
+func (h *Hub) loadFile(path string, tail bool, opts *FileOptions) (loadedFile, error) {
+	return h.loadWith(h.renderer.withOptions(opts), path, tail)
+}
+
+// loadWith is loadFile rendering with renderer.
+func (h *Hub) loadWith(renderer *Renderer, path string, tail bool) (loadedFile, error) {
+	var content []byte
+	var modTime time.Time
+	var name string
+	if isRemotePath(path) {
+		var err error
+		name = remoteName(path)
+		content, modTime, err = fetchRemote(path, h.renderer.config.MaxFileSize)
+		var tooLarge *remoteTooLargeError
+		if errors.As(err, &tooLarge) {
+			size := "over " + formatSize(tooLarge.Limit)
+			if tooLarge.Size >= 0 {
+				size = formatSize(tooLarge.Size)
+			}
+			return loadedFile{html: renderer.tooLarge(name, size), modTime: modTime, size: max(tooLarge.Size, 0), kind: fileKind(name, nil)}, nil
+		}
+		if err != nil {
+			return loadedFile{}, err
+		}
+	} else if isStdinPath(path) {
+		doc, err := h.stdinContent(path)
+		if err != nil {
+			return loadedFile{}, err
+		}
+		content, modTime, name = doc.content, doc.modTime, path
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return loadedFile{}, err
+		}
+		if html, tooLarge := renderer.sizeCheck(path, info.Size()); tooLarge {
+			return loadedFile{html: html, modTime: info.ModTime(), size: info.Size(), kind: fileKind(path, nil)}, nil
+		}
+		content, err = os.ReadFile(path)
+		if err != nil {
+			return loadedFile{}, err
+		}
+		modTime = info.ModTime()
+		name = path
+		if !tail && renderer.streams(path, content) {
+			return loadedFile{
+				modTime:  modTime,
+				size:     int64(len(content)),
+				lines:    countLines(content),
+				hash:     contentHash(content),
+				kind:     fileKind(path, content),
+				streamed: true,
+			}, nil
+		}
+	}
+	return h.renderLoaded(renderer, name, content, modTime, tail)
+}
+
+// renderLoaded renders the content of a watched path read or fetched by the
+// caller; name picks how it renders.
+func (h *Hub) renderLoaded(renderer *Renderer, name string, content []byte, modTime time.Time, tail bool) (loadedFile, error) {
+	render := renderer.RenderContent
+	if tail {
+		render = renderer.RenderTail
+	}
+	// A render that times out still yields a placeholder to show
+	html, err := render(name, content)
+	var source string
+	if h.withSource && (isMarkdown(name) || isRST(name)) && !isBinary(content) {
+		source = string(normalizeNewlines(content))
+	}
+	var renderError string
+	if errors.Is(err, errRenderTimeout) {
+		renderError = err.Error()
+	} else if err != nil {
+		return loadedFile{}, fmt.Errorf("%w: %v", errRenderFailed, err)
+	}
+	return loadedFile{
+		html:        html,
+		modTime:     modTime,
+		size:        int64(len(content)),
+		lines:       countLines(content),
+		hash:        contentHash(content),
+		kind:        fileKind(name, content),
+		source:      source,
+		renderError: renderError,
+	}, nil
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// countLines returns the number of lines in content, or 0 for binary files.
+func countLines(content []byte) int {
+	if len(content) == 0 || isBinary(content) {
+		return 0
+	}
+	content = normalizeNewlines(content)
+	n := bytes.Count(content, []byte("\n"))
+	if content[len(content)-1] != '\n' {
+		n++ // last line without a trailing newline
+	}
+	return n
+}
+
+// normalizeRoots resolves the --root directories the way watched paths are
+// resolved, so the two compare.
+func normalizeRoots(roots []string) []string {
+	var normalized []string
+	for _, root := range roots {
+		normalized = append(normalized, NormalizePath(root))
+	}
+	return normalized
+}
+
+// checkRoots returns errOutsideRoots unless path is under one of the --root
+// directories, or no roots were given. Remote URLs aren't under any root;
+// piped documents aren't files, so the roots don't apply to them.
+func (h *Hub) checkRoots(path string) error {
+	if withinRoots(h.roots, path) || isStdinPath(path) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is not under %s", errOutsideRoots, path, strings.Join(h.roots, ", "))
+}
+
+// withinRoots reports whether path is under one of roots. Every path is
+// when there are no roots.
+func withinRoots(roots []string, path string) bool {
+	if len(roots) == 0 {
+		return true
+	}
+	if isRemotePath(path) {
+		return false
+	}
+	for _, root := range roots {
+		if IsWithin(root, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeWatchPath canonicalizes a path received from a client so that
+// different spellings of the same file (~, symlinks, /var vs /private/var)
+// match the registered entry. Remote URLs and piped documents are returned
+// unchanged.
+func normalizeWatchPath(path string) string {
+	if isRemotePath(path) || isStdinPath(path) {
+		return path
+	}
+	return NormalizePath(path)
+}
+
+// startWatcher starts watching a registered file. If that fails the file is
+// marked inactive with a WatchError, the error is logged, and returned.
+func (h *Hub) startWatcher(path string) error {
+	if isStdinPath(path) {
+		return nil // piped documents change only through SetStdin
+	}
 	h.mu.Lock()
 	// Check if watcher already exists
 	if _, exists := h.watchers[path]; exists {
 		h.mu.Unlock()
-		return
+		return nil
 	}
 
-	watcher := NewWatcher()
+	watcher := h.newWatcher()
+	watcher.OnStall(func(err error) { h.stallFile(path, watcher, err) })
 	h.watchers[path] = watcher
 	h.mu.Unlock()
 
-	// Watch for changes
-	watcher.Watch(path, func() {
-		h.mu.Lock()
-		f, exists := h.files[path]
-		if !exists || !f.Active {
-			h.mu.Unlock()
-			return
-		}
-
-		html, err := h.renderer.Render(path)
-		if err != nil {
-			h.logger.Error(fmt.Sprintf("Error rendering %s: %v", filepath.Base(path), err))
-			h.mu.Unlock()
-			return
-		}
-
-		info, _ := os.Stat(path)
-		f.HTML = html
-		f.LastChange = info.ModTime()
-		f.Deleted = false // file is back if it was marked deleted
-		h.mu.Unlock()
+	onChange := func() { h.fileChanged(path) }
+	onRemoteChange := func(content []byte, modTime time.Time) {
+		h.remoteChanged(path, content, modTime)
+	}
 
-		h.logger.Info(fmt.Sprintf("File changed: %s", filepath.Base(path)))
-		h.broadcastFileUpdate(f)
-	}, func() {
-		// onDelete callback
+	onDelete := func() {
 		h.mu.Lock()
-		f, exists := h.files[path]
+		f, exists := h.watchedFile(path)
 		if !exists {
 			h.mu.Unlock()
 			return
@@ -255,112 +816,541 @@ func (h *Hub) startWatcher(path string) {
 		f.Active = false
 		h.mu.Unlock()
 
-		h.logger.Warn(fmt.Sprintf("File deleted: %s", filepath.Base(path)))
+		h.logger.Warn(fmt.Sprintf("File deleted: %s", f.Name))
 		h.broadcastFileList()
-	})
-}
-
-func (h *Hub) ActivateFile(path string) error {
-	h.mu.Lock()
-
-	// Find the file (case-insensitive on Windows)
-	var actualPath string
-	var file *WatchedFile
-	for existingPath, f := range h.files {
-		if PathsEqual(existingPath, path) {
-			actualPath = existingPath
-			file = f
-			break
-		}
 	}
 
-	if file == nil {
-		h.mu.Unlock()
-		return fmt.Errorf("file not registered: %s", path)
-	}
-
-	if file.Active {
-		h.mu.Unlock()
-		return nil // Already active
+	// Watch for changes; remote URLs are polled
+	var err error
+	if isRemotePath(path) {
+		err = watcher.WatchURL(path, remotePollInterval, onRemoteChange, onDelete)
+	} else if h.shared != nil {
+		err = watcher.WatchShared(h.shared, path, onChange, onDelete)
+	} else {
+		err = watcher.Watch(path, onChange, onDelete)
 	}
 
-	// Refresh content before activating
-	html, err := h.renderer.Render(actualPath)
+	h.mu.Lock()
+	f := h.files[path]
 	if err != nil {
+		delete(h.watchers, path)
+		watcher.Close()
+	}
+	if f == nil {
 		h.mu.Unlock()
 		return err
 	}
-
-	info, _ := os.Stat(actualPath)
-	file.HTML = html
-	file.LastChange = info.ModTime()
-	file.Active = true
+	if err == nil {
+		f.WatchError = ""
+		f.Stalled = false
+		h.mu.Unlock()
+		h.syncIncludeWatchers()
+		return nil
+	}
+	f.Active = false
+	f.WatchError = watchErrorMessage(err)
+	name := f.Name
 	h.mu.Unlock()
 
-	// Start watching
-	h.startWatcher(actualPath)
-
-	h.logger.Info(fmt.Sprintf("Activated watching: %s", filepath.Base(actualPath)))
+	h.logger.Warn(fmt.Sprintf("Can't watch %s: %s", name, watchErrorMessage(err)))
 	h.broadcastFileList()
-	return nil
+	return err
 }
 
-func (h *Hub) DeactivateFile(path string) error {
-	h.mu.Lock()
+// fileChanged re-renders a watched file after a change and sends it to the
+// browsers, or only its new timestamp when the content is the same.
+func (h *Hub) fileChanged(path string) {
+	h.reload(path, func(tail bool, opts *FileOptions) (loadedFile, error) {
+		return h.loadFile(path, tail, opts)
+	})
+}
 
-	// Find the file (case-insensitive on Windows)
-	var actualPath string
-	var file *WatchedFile
-	for existingPath, f := range h.files {
-		if PathsEqual(existingPath, path) {
-			actualPath = existingPath
-			file = f
-			break
-		}
+// remoteChanged is fileChanged for a watched URL, rendering the content its
+// poller just fetched rather than fetching it again. Nil content, for a
+// body over the size limit, is fetched again to show the placeholder.
+func (h *Hub) remoteChanged(path string, content []byte, modTime time.Time) {
+	if content == nil {
+		h.fileChanged(path)
+		return
 	}
+	h.reload(path, func(tail bool, opts *FileOptions) (loadedFile, error) {
+		return h.renderLoaded(h.renderer.withOptions(opts), remoteName(path), content, modTime, tail)
+	})
+}
 
-	if file == nil {
+// reloadFunc renders a watched file for reload, with the file's tail mode
+// and options.
+type reloadFunc func(tail bool, opts *FileOptions) (loadedFile, error)
+
+// reload stores the render load returns for the watched file at path and
+// sends it to the browsers, for fileChanged and remoteChanged. One reload
+// of a file runs at a time: a change arriving while the file renders is
+// rendered once that render is stored, so an older render never replaces
+// a newer one.
+func (h *Hub) reload(path string, load reloadFunc) {
+	h.mu.Lock()
+	f, exists := h.watchedFile(path)
+	if !exists {
 		h.mu.Unlock()
-		return fmt.Errorf("file not registered: %s", path)
+		return
 	}
-
-	if !file.Active {
+	path = f.Path
+	if _, busy := h.reloads[path]; busy {
+		// Only the latest change matters; it replaces any queued one
+		h.reloads[path] = load
 		h.mu.Unlock()
-		return nil // Already inactive
+		return
 	}
+	h.reloads[path] = nil
+	h.mu.Unlock()
 
-	file.Active = false
+	for load != nil {
+		h.reloadOnce(path, load)
 
-	// Stop watcher
-	if w, exists := h.watchers[actualPath]; exists {
-		w.Close()
-		delete(h.watchers, actualPath)
+		h.mu.Lock()
+		load = h.reloads[path]
+		if load == nil {
+			delete(h.reloads, path)
+		} else {
+			h.reloads[path] = nil
+		}
+		h.mu.Unlock()
 	}
-
-	h.mu.Unlock()
-
-	h.logger.Info(fmt.Sprintf("Deactivated watching: %s", filepath.Base(actualPath)))
-	h.broadcastFileList()
-	return nil
 }
 
-func (h *Hub) RemoveFile(path string) error {
+// reloadOnce renders the watched file at path with load and stores the
+// result, for reload.
+func (h *Hub) reloadOnce(path string, load reloadFunc) {
 	h.mu.Lock()
-
-	// Find the actual key (case-insensitive on Windows)
-	var actualPath string
-	var file *WatchedFile
-	for existingPath, f := range h.files {
-		if PathsEqual(existingPath, path) {
-			actualPath = existingPath
-			file = f
-			break
-		}
+	f, exists := h.watchedFile(path)
+	if !exists || (!f.Active && !f.Deleted) {
+		h.mu.Unlock()
+		return
 	}
+	tail, opts := f.Tail, f.Options
+	f.Status = statusPending
+	pending := *f
+	pending.HTML = ""
+	pending.Source = ""
+	h.mu.Unlock()
+	h.broadcastFileStatus(&pending)
 
-	if file == nil {
+	// Render without holding the lock, so a slow file doesn't block
+	// the rest of the hub while it renders
+	loaded, err := load(tail, opts)
+
+	h.mu.Lock()
+	f, exists = h.watchedFile(path)
+	if !exists {
 		h.mu.Unlock()
-		return fmt.Errorf("not watching: %s", path)
+		return
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		h.denyFile(f)
+		return
+	}
+	if err != nil {
+		f.renderFailed(err)
+		failed := *f
+		h.mu.Unlock()
+
+		h.logger.Error(fmt.Sprintf("Error rendering %s: %v", failed.Name, err))
+		h.broadcastFileUpdate(&failed)
+		return
+	}
+	if loaded.unchanged(f) {
+		// Saved or touched without changes: only the timestamp moves, so
+		// browsers update it without reloading the content
+		f.LastChange = loaded.modTime
+		f.settleStatus()
+		touched := *f
+		touched.HTML = ""
+		touched.Source = ""
+		h.mu.Unlock()
+
+		h.broadcastFileTouch(&touched)
+		return
+	}
+
+	loaded.apply(f)
+	if f.Deleted {
+		// File is back (or a remote URL is reachable again)
+		f.Deleted = false
+		f.Active = true
+	}
+	// Sent as a copy, as other goroutines write f once the lock is released
+	update := *f
+	h.mu.Unlock()
+
+	if loaded.renderError != "" {
+		h.logger.Error(fmt.Sprintf("Error rendering %s: %s", update.Name, loaded.renderError))
+	} else {
+		h.logger.Info(fmt.Sprintf("File changed: %s", update.Name))
+	}
+	h.broadcastFileUpdate(&update)
+	h.syncIncludeWatchers()
+}
+
+// pollActive re-checks the modification time of every active local file
+// each interval and re-renders the ones that changed without a watcher
+// event (start --refresh-interval), for filesystems whose events are
+// unreliable. It runs until Close.
+func (h *Hub) pollActive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// The modification time each file was last re-rendered for, so a
+	// file that fails to render isn't retried until it changes again
+	polled := make(map[string]time.Time)
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+
+		h.mu.RLock()
+		shown := make(map[string]time.Time)
+		for path, f := range h.files {
+			if f.Active && !isRemotePath(path) && !isStdinPath(path) {
+				shown[path] = f.LastChange
+			}
+		}
+		h.mu.RUnlock()
+
+		// Stat without the lock, as a slow mount may take a while
+		for path, lastChange := range shown {
+			info, err := os.Stat(path)
+			if err != nil {
+				// Deleted or unreadable files are left to the watcher
+				continue
+			}
+			modTime := info.ModTime()
+			if modTime.Equal(lastChange) || modTime.Equal(polled[path]) {
+				continue
+			}
+			polled[path] = modTime
+			h.logger.Info(fmt.Sprintf("Change picked up by polling: %s", filepath.Base(path)))
+			h.fileChanged(path)
+		}
+		for path := range polled {
+			if _, ok := shown[path]; !ok {
+				delete(polled, path)
+			}
+		}
+	}
+}
+
+// watchedFile finds the file a watcher callback reports for path. The path
+// is normally the registered key, but is matched with PathsEqual too, since
+// on Windows the same file may come back with a differently cased drive
+// letter or name. The caller holds h.mu.
+func (h *Hub) watchedFile(path string) (*WatchedFile, bool) {
+	if f, exists := h.files[path]; exists {
+		return f, true
+	}
+	for existingPath, f := range h.files {
+		if PathsEqual(existingPath, path) {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// stallFile handles a watcher that stopped delivering events for path: the
+// file is marked inactive and stalled, and browsers get a "stalled" message
+// so they can warn that it no longer updates and offer to watch it again.
+func (h *Hub) stallFile(path string, watcher FileWatcher, err error) {
+	h.mu.Lock()
+	f, exists := h.watchedFile(path)
+	if !exists || h.watchers[f.Path] != watcher {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.watchers, f.Path)
+	f.Active = false
+	f.Stalled = true
+	f.WatchError = "stopped watching: " + err.Error()
+	stalled := *f
+	stalled.HTML = ""
+	stalled.Source = ""
+	h.mu.Unlock()
+	watcher.Close()
+
+	h.logger.Warn(fmt.Sprintf("Stopped watching %s: %v", stalled.Name, err))
+	data, _ := json.Marshal(Message{Type: "stalled", File: &stalled})
+	h.broadcast <- queuedMessage{data: data}
+	h.broadcastFileList()
+}
+
+// denyFile marks f as unreadable, once, and tells browsers with a "denied"
+// message so they can say why it stopped updating. f stays watched, so it
+// is shown again when it becomes readable. The caller holds h.mu, which is
+// released.
+func (h *Hub) denyFile(f *WatchedFile) {
+	if f.PermissionDenied {
+		f.settleStatus()
+		status := *f
+		status.HTML = ""
+		status.Source = ""
+		h.mu.Unlock()
+		h.broadcastFileStatus(&status)
+		return
+	}
+	f.PermissionDenied = true
+	f.settleStatus()
+	denied := *f
+	denied.HTML = ""
+	denied.Source = ""
+	h.mu.Unlock()
+
+	h.logger.Warn(fmt.Sprintf("Can't read %s: permission denied", denied.Name))
+	data, _ := json.Marshal(Message{Type: "denied", File: &denied})
+	h.broadcast <- queuedMessage{data: data}
+	h.broadcastFileList()
+}
+
+// watchErrorMessage explains a failure to watch a file, pointing at the
+// system limit to raise when one was hit.
+func watchErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		return "watch limit reached, increase fs.inotify.max_user_watches"
+	case errors.Is(err, syscall.EMFILE):
+		return "too many open files, increase fs.inotify.max_user_instances or start with --single-watcher"
+	}
+	return err.Error()
+}
+
+func (h *Hub) ActivateFile(path string) error {
+	path = normalizeWatchPath(path)
+	h.mu.RLock()
+
+	// Find the file under any spelling of its path, as ResolvePath does
+	var actualPath string
+	var file *WatchedFile
+	for existingPath, f := range h.files {
+		if SameFile(existingPath, path) {
+			actualPath = existingPath
+			file = f
+			break
+		}
+	}
+
+	if file == nil {
+		h.mu.RUnlock()
+		return fmt.Errorf("%w: %s", errNotWatched, path)
+	}
+
+	if file.Active {
+		h.mu.RUnlock()
+		return nil // Already active
+	}
+	tail, opts := file.Tail, file.Options
+	h.mu.RUnlock()
+
+	if h.activateAdding(file) {
+		return nil
+	}
+
+	// Refresh content before activating, without holding the lock. An
+	// unreadable file is watched anyway, to show it once it becomes
+	// readable.
+	loaded, err := h.loadFile(actualPath, tail, opts)
+
+	h.mu.Lock()
+	if h.files[actualPath] != file {
+		h.mu.Unlock()
+		return fmt.Errorf("%w: %s", errNotWatched, path)
+	}
+	if file.Active {
+		h.mu.Unlock()
+		return nil // Activated while it rendered
+	}
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		file.PermissionDenied = true
+		file.settleStatus()
+		h.logger.Warn(fmt.Sprintf("Can't read %s: permission denied", file.Name))
+	case err != nil:
+		file.renderFailed(err)
+		failed := *file
+		h.mu.Unlock()
+		h.broadcastFileUpdate(&failed)
+		return err
+	default:
+		loaded.apply(file)
+	}
+	file.Active = true
+	h.mu.Unlock()
+
+	// Start watching
+	if err := h.startWatcher(actualPath); err != nil {
+		return err
+	}
+
+	h.logger.Info(fmt.Sprintf("Activated watching: %s", file.Name))
+	h.broadcastFileList()
+	return nil
+}
+
+func (h *Hub) DeactivateFile(path string) error {
+	path = normalizeWatchPath(path)
+	h.mu.Lock()
+
+	// Find the file under any spelling of its path, as ResolvePath does
+	var actualPath string
+	var file *WatchedFile
+	for existingPath, f := range h.files {
+		if SameFile(existingPath, path) {
+			actualPath = existingPath
+			file = f
+			break
+		}
+	}
+
+	if file == nil {
+		h.mu.Unlock()
+		return fmt.Errorf("%w: %s", errNotWatched, path)
+	}
+
+	if !file.Active {
+		h.mu.Unlock()
+		return nil // Already inactive
+	}
+
+	file.Active = false
+
+	// Stop watcher
+	if w, exists := h.watchers[actualPath]; exists {
+		w.Close()
+		delete(h.watchers, actualPath)
+	}
+
+	name := file.Name
+	h.mu.Unlock()
+	h.syncIncludeWatchers()
+
+	h.logger.Info(fmt.Sprintf("Deactivated watching: %s", name))
+	h.broadcastFileList()
+	return nil
+}
+
+// activateAdding marks a file that is still being added as active, for
+// AddFileWithActive to start its watcher once the first render is stored.
+// It reports false when the file has been added, to be activated as usual.
+func (h *Hub) activateAdding(f *WatchedFile) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !f.adding {
+		return false
+	}
+	f.Active = true
+	return true
+}
+
+// ActivateAll starts watching every registered file that isn't watched yet,
+// sending a single file list update at the end. It returns the number of
+// files activated; files that fail to render are left inactive, and files
+// still being added are watched once their first render is stored.
+func (h *Hub) ActivateAll() int {
+	h.mu.RLock()
+	var paths []string
+	for path, f := range h.files {
+		if !f.Active && !f.Deleted {
+			paths = append(paths, path)
+		}
+	}
+	h.mu.RUnlock()
+
+	count := 0
+	for _, path := range paths {
+		h.mu.RLock()
+		f, exists := h.files[path]
+		if !exists || f.Active || f.Deleted {
+			h.mu.RUnlock()
+			continue
+		}
+		tail, opts := f.Tail, f.Options
+		h.mu.RUnlock()
+
+		if h.activateAdding(f) {
+			continue
+		}
+
+		// Rendered without the lock, so other requests aren't held up
+		loaded, err := h.loadFile(path, tail, opts)
+
+		h.mu.Lock()
+		if h.files[path] != f || f.Active || f.Deleted {
+			h.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			f.renderFailed(err)
+			h.mu.Unlock()
+			continue
+		}
+		loaded.apply(f)
+		f.Active = true
+		h.mu.Unlock()
+
+		if h.startWatcher(path) == nil {
+			count++
+		}
+	}
+
+	if count > 0 {
+		h.logger.Info(fmt.Sprintf("Activated watching: %d file(s)", count))
+		h.broadcastFileList()
+	}
+	return count
+}
+
+// DeactivateAll stops watching every active file, sending a single file
+// list update. It returns the number of files deactivated.
+func (h *Hub) DeactivateAll() int {
+	h.mu.Lock()
+	count := 0
+	for path, f := range h.files {
+		if !f.Active {
+			continue
+		}
+		f.Active = false
+		if w, exists := h.watchers[path]; exists {
+			w.Close()
+			delete(h.watchers, path)
+		}
+		count++
+	}
+	h.mu.Unlock()
+
+	if count > 0 {
+		h.syncIncludeWatchers()
+		h.logger.Info(fmt.Sprintf("Deactivated watching: %d file(s)", count))
+		h.broadcastFileList()
+	}
+	return count
+}
+
+func (h *Hub) RemoveFile(path string) error {
+	path = normalizeWatchPath(path)
+	h.mu.Lock()
+
+	// Find the actual key under any spelling of its path, as ResolvePath does
+	var actualPath string
+	var file *WatchedFile
+	for existingPath, f := range h.files {
+		if SameFile(existingPath, path) {
+			actualPath = existingPath
+			file = f
+			break
+		}
+	}
+
+	if file == nil {
+		h.mu.Unlock()
+		return fmt.Errorf("%w: %s", errNotWatched, path)
 	}
 	name := file.Name
 
@@ -372,19 +1362,22 @@ func (h *Hub) RemoveFile(path string) error {
 
 	delete(h.files, actualPath)
 	h.mu.Unlock()
+	h.dropStdin(actualPath)
+	h.forgetRemoved([]string{actualPath})
 
 	h.logger.Info(fmt.Sprintf("Stopped watching: %s", name))
 
 	// Broadcast removal
 	msg := Message{Type: "removed", Path: actualPath}
 	data, _ := json.Marshal(msg)
-	h.broadcast <- data
+	h.broadcast <- queuedMessage{data: data}
 
 	h.saveState()
 	return nil
 }
 
 func (h *Hub) RemoveFolder(folderPath string) int {
+	folderPath = normalizeWatchPath(folderPath)
 	h.mu.Lock()
 	var toRemove []string
 	prefix := folderPath + "/"
@@ -401,6 +1394,7 @@ func (h *Hub) RemoveFolder(folderPath string) int {
 		delete(h.files, path)
 	}
 	h.mu.Unlock()
+	h.forgetRemoved(toRemove)
 
 	if len(toRemove) > 0 {
 		h.logger.Info(fmt.Sprintf("Removed %d file(s) from folder: %s", len(toRemove), filepath.Base(folderPath)))
@@ -426,6 +1420,7 @@ func (h *Hub) RemoveDeletedFiles() int {
 		delete(h.files, path)
 	}
 	h.mu.Unlock()
+	h.forgetRemoved(toRemove)
 
 	if len(toRemove) > 0 {
 		h.logger.Info(fmt.Sprintf("Removed %d deleted file(s)", len(toRemove)))
@@ -435,146 +1430,1082 @@ func (h *Hub) RemoveDeletedFiles() int {
 	return len(toRemove)
 }
 
-func (h *Hub) GetFiles() []WatchedFile {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// forgetRemoved drops the includes recorded for removed files and stops
+// watching the files only they included.
+func (h *Hub) forgetRemoved(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	for _, path := range paths {
+		h.renderer.ForgetIncludes(path)
+	}
+	h.syncIncludeWatchers()
+}
 
-	files := make([]WatchedFile, 0, len(h.files))
-	for _, f := range h.files {
-		files = append(files, *f)
+// SetLabel sets a custom display name for a watched file. An empty label
+// reverts to the file name.
+func (h *Hub) SetLabel(path, label string) error {
+	if !h.setLabel(path, label) {
+		return fmt.Errorf("file not registered: %s", path)
 	}
-	return files
+	h.broadcastFileList()
+	h.saveState()
+	return nil
 }
 
-func (h *Hub) Close() {
+// setLabel sets the label without broadcasting or saving state. It reports
+// whether the file was found.
+func (h *Hub) setLabel(path, label string) bool {
+	actualPath, ok := h.ResolvePath(path)
+	if !ok {
+		return false
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
-
-	for _, w := range h.watchers {
-		w.Close()
+	f, exists := h.files[actualPath]
+	if !exists {
+		return false
 	}
+	f.Label = strings.TrimSpace(label)
+	return true
 }
 
-// Server handles HTTP and WebSocket
-type Server struct {
-	hub    *Hub
-	port   int
-	server *http.Server
+// SetMuted mutes or unmutes a file. A muted file is still watched and its
+// changes still update the file list, but browsers never switch to it on
+// their own.
+func (h *Hub) SetMuted(path string, muted bool) error {
+	if !h.setMuted(path, muted) {
+		return fmt.Errorf("file not registered: %s", path)
+	}
+	h.broadcastFileList()
+	h.saveState()
+	return nil
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+// setMuted sets Muted without broadcasting or saving state. It reports
+// whether the file was found.
+func (h *Hub) setMuted(path string, muted bool) bool {
+	actualPath, ok := h.ResolvePath(path)
+	if !ok {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, exists := h.files[actualPath]
+	if !exists {
+		return false
+	}
+	f.Muted = muted
+	return true
 }
 
-func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
-		return
+// SetTail switches a file's tail mode, in which code shows its last lines
+// and browsers keep scrolled to the end, and re-renders it.
+func (h *Hub) SetTail(path string, tail bool) error {
+	actualPath, ok := h.setTail(path, tail)
+	if !ok {
+		return fmt.Errorf("file not registered: %s", path)
+	}
+	f, err := h.refresh(actualPath, false)
+	if f != nil {
+		h.broadcastFileUpdate(f)
 	}
+	h.saveState()
+	return err
+}
 
-	client := &Client{
-		hub:  s.hub,
-		conn: conn,
-		send: make(chan []byte, 256),
+// setTail sets a file's tail mode without re-rendering it, and returns the
+// path it is registered under.
+func (h *Hub) setTail(path string, tail bool) (string, bool) {
+	actualPath, ok := h.ResolvePath(path)
+	if !ok {
+		return "", false
 	}
 
-	s.hub.register <- client
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, exists := h.files[actualPath]
+	if !exists {
+		return "", false
+	}
+	f.Tail = tail
+	return actualPath, true
+}
 
-	// Writer goroutine
+func (h *Hub) isTail(path string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	f, exists := h.files[path]
+	return exists && f.Tail
+}
+
+// SetOptions stores a file's render options and re-renders it. Zero
+// options go back to the server's settings.
+func (h *Hub) SetOptions(path string, opts FileOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	actualPath, ok := h.setOptions(path, opts)
+	if !ok {
+		return fmt.Errorf("file not registered: %s", path)
+	}
+	f, err := h.refresh(actualPath, false)
+	if f != nil {
+		h.broadcastFileUpdate(f)
+	}
+	h.saveState()
+	return err
+}
+
+// setOptions sets a file's render options without re-rendering it, and
+// returns the path it is registered under.
+func (h *Hub) setOptions(path string, opts FileOptions) (string, bool) {
+	actualPath, ok := h.ResolvePath(path)
+	if !ok {
+		return "", false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, exists := h.files[actualPath]
+	if !exists {
+		return "", false
+	}
+	f.Options = nil
+	if !opts.IsZero() {
+		f.Options = &opts
+	}
+	return actualPath, true
+}
+
+// options returns the render options of a registered file, nil for none.
+func (h *Hub) options(path string) *FileOptions {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if f, exists := h.files[path]; exists {
+		return f.Options
+	}
+	return nil
+}
+
+// RefreshFile re-renders a watched file and broadcasts the new HTML. The
+// file is rendered from scratch rather than taken from the render cache,
+// which other files keep.
+func (h *Hub) RefreshFile(path string) error {
+	actualPath, ok := h.ResolvePath(path)
+	if !ok {
+		return fmt.Errorf("file not registered: %s", path)
+	}
+
+	f, err := h.refresh(actualPath, true)
+	if f != nil {
+		h.broadcastFileUpdate(f)
+	}
+	if err != nil {
+		return err
+	}
+	h.logger.Info(fmt.Sprintf("Refreshed: %s", f.Name))
+	return nil
+}
+
+// PreviewFile renders a registered file once and returns the fresh HTML.
+// Nothing is stored or broadcast: the file keeps its Active state and its
+// last render, and no watcher is started, so an inactive file can show
+// current content without being watched. The render (or fetch of a URL)
+// runs without the lock. A file shown by streaming (see Renderer.streams)
+// isn't rendered; errStreamed is returned instead.
+func (h *Hub) PreviewFile(path string) (string, error) {
+	actualPath, ok := h.ResolvePath(path)
+	if !ok {
+		return "", fmt.Errorf("file not registered: %s", path)
+	}
+
+	h.mu.RLock()
+	f, exists := h.files[actualPath]
+	if !exists {
+		h.mu.RUnlock()
+		return "", fmt.Errorf("file not registered: %s", path)
+	}
+	tail, opts := f.Tail, f.Options
+	h.mu.RUnlock()
+
+	loaded, err := h.loadFile(actualPath, tail, opts)
+	if err != nil {
+		return "", err
+	}
+	if loaded.streamed {
+		return "", errStreamed
+	}
+	return loaded.html, nil
+}
+
+// RefreshAll re-renders every watched file that exists, from scratch, and
+// returns how many rendered successfully.
+func (h *Hub) RefreshAll() int {
+	h.mu.RLock()
+	var paths []string
+	for path, f := range h.files {
+		if !f.Deleted {
+			paths = append(paths, path)
+		}
+	}
+	h.mu.RUnlock()
+
+	count := 0
+	for _, path := range paths {
+		if _, err := h.refresh(path, true); err == nil {
+			count++
+		}
+	}
+
+	h.logger.Info(fmt.Sprintf("Refreshed %d file(s)", count))
+	h.broadcastFileList()
+	return count
+}
+
+// refresh re-renders the file registered under path, recording a failure in
+// its RenderError. It returns a copy of the file, to broadcast, or nil if it
+// is no longer registered. The render runs without holding h.mu, as in
+// fileChanged. With fresh, the render cache isn't looked up.
+func (h *Hub) refresh(path string, fresh bool) (*WatchedFile, error) {
+	h.mu.RLock()
+	f, exists := h.files[path]
+	if !exists {
+		h.mu.RUnlock()
+		return nil, fmt.Errorf("file not registered: %s", path)
+	}
+	tail, opts, revision := f.Tail, f.Options, f.Revision
+	h.mu.RUnlock()
+
+	renderer := h.renderer.withOptions(opts)
+	if fresh {
+		renderer = renderer.uncached()
+	}
+	loaded, err := h.loadWith(renderer, path, tail)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, exists = h.files[path]
+	if !exists {
+		return nil, fmt.Errorf("file not registered: %s", path)
+	}
+	update := *f
+	if f.Tail != tail || f.Options != opts {
+		// Switched while it rendered; the refresh that follows the switch
+		// stores the current render
+		return &update, nil
+	}
+	if f.Revision != revision && !loaded.modTime.After(f.LastChange) {
+		// Rendered again meanwhile, from content at least as new
+		return &update, nil
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		f.PermissionDenied = true
+		f.settleStatus()
+	} else if err != nil {
+		f.renderFailed(err)
+	} else {
+		loaded.apply(f)
+	}
+	update = *f
+	return &update, err
+}
+
+// refreshIncluders re-renders the active files that include path (start
+// --includes), so they show its changes too.
+func (h *Hub) refreshIncluders(path string) {
+	for _, parent := range h.renderer.Includers(path) {
+		h.mu.RLock()
+		f, exists := h.files[parent]
+		active := exists && f.Active
+		h.mu.RUnlock()
+		if !active {
+			continue
+		}
+
+		f, err := h.refresh(parent, false)
+		if err != nil {
+			h.logger.Error(fmt.Sprintf("Error rendering %s: %v", filepath.Base(parent), err))
+		}
+		if f != nil {
+			h.broadcastFileUpdate(f)
+		}
+	}
+	h.syncIncludeWatchers()
+}
+
+// syncIncludeWatchers watches the files that active files include, and
+// stops watching the ones no longer included. An included file changing or
+// going away re-renders the files including it.
+func (h *Hub) syncIncludeWatchers() {
+	if !h.renderer.config.Includes {
+		return
+	}
+
+	h.mu.RLock()
+	needed := make(map[string]bool)
+	for p, f := range h.files {
+		if f.Active {
+			for _, included := range h.renderer.Includes(p) {
+				needed[included] = true
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	h.includeMu.Lock()
+	defer h.includeMu.Unlock()
+	for p, w := range h.includeWatchers {
+		if !needed[p] {
+			w.Close()
+			delete(h.includeWatchers, p)
+		}
+	}
+	for p := range needed {
+		if _, exists := h.includeWatchers[p]; exists {
+			continue
+		}
+		// Always a watcher of its own: the file may also be watched itself,
+		// through the shared watcher, which holds one callback per path
+		path := p
+		refresh := func() { h.refreshIncluders(path) }
+		w := h.newWatcher()
+		// A stalled include is watched anew on the next sync
+		w.OnStall(func(err error) {
+			h.logger.Warn(fmt.Sprintf("Stopped watching included %s: %v", filepath.Base(path), err))
+			h.includeMu.Lock()
+			if h.includeWatchers[path] == w {
+				delete(h.includeWatchers, path)
+			}
+			h.includeMu.Unlock()
+			w.Close()
+		})
+		if err := w.Watch(path, refresh, refresh); err != nil {
+			h.logger.Warn(fmt.Sprintf("Can't watch included %s: %s", filepath.Base(path), watchErrorMessage(err)))
+			continue
+		}
+		h.includeWatchers[path] = w
+	}
+}
+
+// SelectFile tells every connected browser to show path, e.g. to drive a
+// shared display from a script.
+func (h *Hub) SelectFile(path string) error {
+	actualPath, ok := h.ResolvePath(path)
+	if !ok {
+		return fmt.Errorf("not watching: %s", path)
+	}
+
+	h.mu.Lock()
+	f := h.files[actualPath]
+	if f == nil || f.Deleted {
+		h.mu.Unlock()
+		return fmt.Errorf("file deleted: %s", filepath.Base(actualPath))
+	}
+	h.selected = actualPath
+	name := f.Name
+	h.mu.Unlock()
+
+	h.logger.Info(fmt.Sprintf("Selected: %s", name))
+	msg := Message{Type: "select", Path: actualPath}
+	data, _ := json.Marshal(msg)
+	h.broadcast <- queuedMessage{data: data, key: "select"}
+	return nil
+}
+
+// Selected returns the file last chosen with SelectFile, or "" if there is
+// none or it has since been removed.
+func (h *Hub) Selected() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if _, ok := h.files[h.selected]; !ok {
+		return ""
+	}
+	return h.selected
+}
+
+// ResolvePath returns the registered path matching path (case-insensitive on Windows).
+func (h *Hub) ResolvePath(path string) (string, bool) {
+	path = normalizeWatchPath(path)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for existingPath := range h.files {
+		if SameFile(existingPath, path) {
+			return existingPath, true
+		}
+	}
+	return "", false
+}
+
+func (h *Hub) GetFiles() []WatchedFile {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	files := make([]WatchedFile, 0, len(h.files))
+	for _, f := range h.files {
+		files = append(files, *f)
+	}
+	return files
+}
+
+// GetFile returns the file registered under any spelling of path.
+func (h *Hub) GetFile(path string) (WatchedFile, bool) {
+	actualPath, ok := h.ResolvePath(path)
+	if !ok {
+		return WatchedFile{}, false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	f, exists := h.files[actualPath]
+	if !exists {
+		return WatchedFile{}, false
+	}
+	return *f, true
+}
+
+// Close closes every browser connection with a "going away" close frame,
+// so browsers can tell the server stopped from a dropped connection, and
+// stops all watchers.
+func (h *Hub) Close() {
+	select {
+	case h.closeClients <- struct{}{}:
+		done := make(chan struct{})
+		go func() {
+			h.writers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(clientCloseTimeout):
+		}
+	case <-time.After(clientCloseTimeout):
+	}
+	close(h.stop)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, w := range h.watchers {
+		w.Close()
+	}
+	h.includeMu.Lock()
+	for _, w := range h.includeWatchers {
+		w.Close()
+	}
+	h.includeMu.Unlock()
+	if h.shared != nil {
+		h.shared.Close()
+	}
+}
+
+// Server handles HTTP and WebSocket
+type Server struct {
+	hub        *Hub
+	port       int
+	extensions []string
+	allowOpen  bool
+	customCSS  string
+	config     ServerConfig // settings the server was started with, for /api/config
+	started    time.Time
+	server     *http.Server
+}
+
+// StatusInfo describes the running server, as returned by /api/status.
+type StatusInfo struct {
+	Version   string    `json:"version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	Port      int       `json:"port"`
+	PID       int       `json:"pid"`
+	Started   time.Time `json:"started"`
+	Files     int       `json:"files"`
+	AllowOpen bool      `json:"allowOpen"`      // start --allow-open: editor/reveal endpoints enabled
+	Name      string    `json:"name,omitempty"` // instance name from start --name
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:    1024,
+	WriteBufferSize:   1024,
+	EnableCompression: true, // permessage-deflate; rendered HTML compresses well
+	// CheckOrigin is set from the origin policy in StartServer
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.hub.logger.Warn(fmt.Sprintf("WebSocket upgrade error: %v", err))
+		return
+	}
+
+	client := &Client{
+		hub:  s.hub,
+		conn: conn,
+		send: make(chan []byte, 256),
+	}
+
+	s.hub.writers.Add(1)
+	s.hub.register <- client
+
+	// Writer goroutine: sends messages and heartbeats, and a close frame
+	// once the hub closes send
 	go func() {
+		defer s.hub.writers.Done()
 		defer conn.Close()
-		for message := range client.send {
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		write := func(message []byte) bool {
 			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			return conn.WriteMessage(websocket.TextMessage, message) == nil
+		}
+		for {
+			message := heartbeatMessage
+			select {
+			case m, ok := <-client.send:
+				if !ok {
+					code, reason := client.closeCode, ""
+					switch code {
+					case websocket.CloseGoingAway:
+						reason = "server shutting down"
+					case websocket.CloseTryAgainLater:
+						reason = "too slow to keep up"
+					default:
+						code = websocket.CloseNormalClosure
+					}
+					conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+					return
+				}
+				message = m
+			case <-ticker.C:
+			}
+			if !write(message) {
 				return
 			}
+			// What waited for send to drain comes after it, in order
+			if len(client.send) == 0 {
+				for _, m := range client.takeOverflow() {
+					if !write(m.data) {
+						return
+					}
+				}
+			}
 		}
 	}()
 
-	// Reader goroutine (detect disconnect)
+	// Reader goroutine: handles requests from the browser and detects
+	// disconnect
 	go func() {
 		defer func() {
 			s.hub.unregister <- client
 			conn.Close()
 		}()
 		for {
-			if _, _, err := conn.ReadMessage(); err != nil {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
 				break
 			}
+			s.handleClientMessage(data)
 		}
 	}()
 }
 
-func (s *Server) handleAddFile(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Path   string `json:"path"`
-		Active bool   `json:"active"`
+// handleClientMessage acts on a message sent by the browser:
+// {"type": "activate", "path": ...} or {"type": "deactivate", "path": ...}.
+// Other messages are ignored.
+func (s *Server) handleClientMessage(data []byte) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Path == "" {
+		return
+	}
+	var err error
+	switch msg.Type {
+	case "activate":
+		err = s.hub.ActivateFile(msg.Path)
+	case "deactivate":
+		err = s.hub.DeactivateFile(msg.Path)
+	default:
+		return
+	}
+	if err != nil {
+		s.hub.logger.Warn(fmt.Sprintf("Can't %s %s: %v", msg.Type, filepath.Base(msg.Path), err))
+	}
+}
+
+func (s *Server) handleAddFile(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path   string `json:"path"`
+		Active bool   `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, codeInvalidRequest, "Invalid request")
+		return
+	}
+
+	if err := s.hub.AddFileWithActive(req.Path, req.Active); err != nil {
+		writeAddError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Errors from AddFileWithActive that callers tell apart by their code
+var (
+	errAlreadyRegistered = errors.New("already registered")
+	errRenderFailed      = errors.New("render failed")
+	errOutsideRoots      = errors.New("outside the allowed roots")
+	// errNotWatched is returned by ActivateFile, DeactivateFile and
+	// RemoveFile for a path that isn't registered
+	errNotWatched = errors.New("not watching")
+	// errStreamed is returned by PreviewFile for a file to stream with
+	// RenderTo instead
+	errStreamed = errors.New("rendered by streaming")
+)
+
+// Error codes returned by the watch API
+const (
+	codeInvalidRequest    = "invalid_request"
+	codeAlreadyRegistered = "already_registered"
+	codeNotFound          = "not_found"
+	codeRenderFailed      = "render_failed"
+	codeAddFailed         = "add_failed"
+	codeOutsideRoots      = "outside_roots"
+	codeWatchFailed       = "watch_failed"
+)
+
+// apiError is the JSON body of a failed watch API request. Code is stable
+// for clients to check; Message is for people.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"error"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{Code: code, Message: message})
+}
+
+// writeWatchError reports a failed ActivateFile, DeactivateFile or
+// RemoveFile with the code matching its cause.
+func writeWatchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNotWatched), errors.Is(err, fs.ErrNotExist):
+		writeAPIError(w, http.StatusNotFound, codeNotFound, err.Error())
+	case errors.Is(err, errRenderFailed):
+		writeAPIError(w, http.StatusInternalServerError, codeRenderFailed, err.Error())
+	default:
+		writeAPIError(w, http.StatusBadRequest, codeWatchFailed, err.Error())
+	}
+}
+
+// writeAddError reports a failed AddFileWithActive with the code matching
+// its cause.
+func writeAddError(w http.ResponseWriter, err error) {
+	var statusErr *remoteStatusError
+	switch {
+	case errors.Is(err, errAlreadyRegistered):
+		writeAPIError(w, http.StatusConflict, codeAlreadyRegistered, err.Error())
+	case errors.Is(err, fs.ErrNotExist),
+		errors.As(err, &statusErr) && statusErr.Status == http.StatusNotFound:
+		writeAPIError(w, http.StatusNotFound, codeNotFound, err.Error())
+	case errors.Is(err, errOutsideRoots):
+		writeAPIError(w, http.StatusForbidden, codeOutsideRoots, err.Error())
+	case errors.Is(err, errRenderFailed):
+		writeAPIError(w, http.StatusInternalServerError, codeRenderFailed, err.Error())
+	default:
+		writeAPIError(w, http.StatusBadRequest, codeAddFailed, err.Error())
+	}
+}
+
+func (s *Server) handleActivateFile(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeAPIError(w, http.StatusBadRequest, codeInvalidRequest, "Missing path parameter")
+		return
+	}
+
+	if err := s.hub.ActivateFile(path); err != nil {
+		writeWatchError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleDeactivateFile(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeAPIError(w, http.StatusBadRequest, codeInvalidRequest, "Missing path parameter")
+		return
+	}
+
+	if err := s.hub.DeactivateFile(path); err != nil {
+		writeWatchError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleRemoveFile(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeAPIError(w, http.StatusBadRequest, codeInvalidRequest, "Missing path parameter")
+		return
+	}
+
+	if err := s.hub.RemoveFile(path); err != nil {
+		writeWatchError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleListFiles lists every file with its HTML, or with ?path= returns
+// one file, which is how browsers fetch a file missing from the list.
+func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
+	if path := r.URL.Query().Get("path"); path != "" {
+		file, ok := s.hub.GetFile(path)
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("%v: %s", errNotWatched, path))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(file)
+		return
+	}
+	files := s.hub.GetFiles()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// handleContent serves the raw, unrendered content of a watched file with a
+// content type based on its extension. Only watched files can be fetched, so
+// this can't be used to read arbitrary files.
+func (s *Server) handleContent(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return
+	}
+
+	actualPath, ok := s.hub.ResolvePath(path)
+	if !ok {
+		http.Error(w, fmt.Sprintf("not watching: %s", path), http.StatusNotFound)
+		return
+	}
+	if err := s.hub.checkRoots(actualPath); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// Watched .html/.svg files must not run scripts on the livemd origin.
+	// With --html-preview pages may run scripts, still in a unique origin.
+	if s.hub.renderer.htmlPreview(actualPath) {
+		w.Header().Set("Content-Security-Policy", "sandbox allow-scripts")
+	} else {
+		w.Header().Set("Content-Security-Policy", "sandbox")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if isRemotePath(actualPath) {
+		content, modTime, err := fetchRemote(actualPath, s.config.Renderer.MaxFileSize)
+		var tooLarge *remoteTooLargeError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		http.ServeContent(w, r, remoteName(actualPath), modTime, bytes.NewReader(content))
+		return
+	}
+	if isStdinPath(actualPath) {
+		doc, err := s.hub.stdinContent(actualPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, strings.TrimPrefix(actualPath, stdinPrefix), doc.modTime, bytes.NewReader(doc.content))
+		return
+	}
+
+	f, err := os.Open(actualPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// handleSelect switches every connected browser to a file (POST ?path=), or
+// reports the file last selected this way (GET).
+func (s *Server) handleSelect(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"path": s.hub.Selected()})
+	case http.MethodPost:
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "Missing path parameter", http.StatusBadRequest)
+			return
+		}
+		if err := s.hub.SelectFile(path); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleOpen runs open (an editor or file manager) on the server host for a
+// watched local file. It launches programs as the server's user, so it is
+// refused unless the server was started with --allow-open.
+func (s *Server) handleOpen(w http.ResponseWriter, r *http.Request, action string, open func(string) error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+	if !s.allowOpen {
+		http.Error(w, "opening files is disabled; start the server with --allow-open", http.StatusForbidden)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
 		return
 	}
 
-	if err := s.hub.AddFileWithActive(req.Path, req.Active); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	actualPath, ok := s.hub.ResolvePath(path)
+	if !ok {
+		http.Error(w, fmt.Sprintf("not watching: %s", path), http.StatusNotFound)
+		return
+	}
+	if isRemotePath(actualPath) || isStdinPath(actualPath) {
+		http.Error(w, "remote files and piped content can't be opened locally", http.StatusBadRequest)
 		return
 	}
 
+	if err := open(actualPath); err != nil {
+		s.hub.logger.Error(fmt.Sprintf("%s failed for %s: %v", action, filepath.Base(actualPath), err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.hub.logger.Info(fmt.Sprintf("%s: %s", action, filepath.Base(actualPath)))
 	w.WriteHeader(http.StatusOK)
 }
 
-func (s *Server) handleActivateFile(w http.ResponseWriter, r *http.Request) {
+// notFound responds with the embedded 404 page. API clients get a plain
+// text 404 instead, since they aren't going to render HTML.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := staticFiles.ReadFile("static/404.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(data)
+}
+
+// handleRender streams the rendered HTML of a watched file. Unlike the
+// WebSocket messages, the output is written as it is produced rather than
+// built up in memory first, which matters for very large files.
+func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
 		http.Error(w, "Missing path parameter", http.StatusBadRequest)
 		return
 	}
 
-	if err := s.hub.ActivateFile(path); err != nil {
+	actualPath, ok := s.hub.ResolvePath(path)
+	if !ok {
+		http.Error(w, fmt.Sprintf("not watching: %s", path), http.StatusNotFound)
+		return
+	}
+
+	// hl=10-15,20 highlights lines of a code file
+	hl, err := parseLineRanges(r.URL.Query().Get("hl"))
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if tail := s.hub.isTail(actualPath); tail || isRemotePath(actualPath) || isStdinPath(actualPath) {
+		// Tail mode needs the whole file to find its end, so it isn't streamed
+		loaded, err := s.hub.loadFile(actualPath, tail, s.hub.options(actualPath))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, loaded.html)
+		return
+	}
+	cw := &countingWriter{w: w}
+	if err := s.hub.renderer.withOptions(s.hub.options(actualPath)).RenderTo(cw, actualPath, hl); err != nil {
+		if cw.n == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// The status and part of the body are already sent
+		s.hub.logger.Error(fmt.Sprintf("Error streaming %s: %v", actualPath, err))
+	}
 }
 
-func (s *Server) handleDeactivateFile(w http.ResponseWriter, r *http.Request) {
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// handlePreview renders a file fresh without activating it, for browsers
+// showing a registered but unwatched file.
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
 		http.Error(w, "Missing path parameter", http.StatusBadRequest)
 		return
 	}
-
-	if err := s.hub.DeactivateFile(path); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if _, ok := s.hub.ResolvePath(path); !ok {
+		http.Error(w, fmt.Sprintf("not watching: %s", path), http.StatusNotFound)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	html, err := s.hub.PreviewFile(path)
+	if errors.Is(err, errStreamed) {
+		s.handleRender(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, html)
 }
 
-func (s *Server) handleRemoveFile(w http.ResponseWriter, r *http.Request) {
+// handleLinkCheck reports the links and images of a watched markdown file
+// whose targets are missing (GET ?path=). recursive=true also checks the
+// markdown files it links to, external=true probes web links. Broken links
+// are logged as warnings too.
+func (s *Server) handleLinkCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	path := r.URL.Query().Get("path")
 	if path == "" {
 		http.Error(w, "Missing path parameter", http.StatusBadRequest)
 		return
 	}
 
-	if err := s.hub.RemoveFile(path); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	actualPath, ok := s.hub.ResolvePath(path)
+	if !ok {
+		http.Error(w, fmt.Sprintf("not watching: %s", path), http.StatusNotFound)
+		return
+	}
+	if err := s.hub.checkRoots(actualPath); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if isRemotePath(actualPath) || isStdinPath(actualPath) || !isMarkdown(actualPath) {
+		http.Error(w, fmt.Sprintf("not a local markdown file: %s", path), http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	q := r.URL.Query()
+	result, err := checkLinks(actualPath, s.hub.roots, q.Get("recursive") == "true", q.Get("external") == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, b := range result.Broken {
+		s.hub.logger.Warn(fmt.Sprintf("Broken link in %s:%d: %s (%s)", filepath.Base(b.File), b.Line, b.Target, b.Error))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
-func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
-	files := s.hub.GetFiles()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(files)
+// handleRenderContent renders content posted as JSON ({"filename": ...,
+// "content": ...}) the way a watched file of that name would be, without
+// registering anything. Only the base name is used, so nothing is read from
+// disk (no includes).
+func (s *Server) handleRenderContent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Filename string `json:"filename"`
+		Content  string `json:"content"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.postedContentLimit())).Decode(&req); err != nil {
+		s.postedContentError(w, err)
+		return
+	}
+	name := filepath.Base(filepath.FromSlash(req.Filename))
+	if req.Filename == "" || name == "." || name == string(filepath.Separator) {
+		http.Error(w, "Missing filename", http.StatusBadRequest)
+		return
+	}
+
+	html, err := s.hub.renderer.RenderContent(name, []byte(req.Content))
+	if err != nil && !errors.Is(err, errRenderTimeout) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, html)
+}
+
+// maxPostedSize bounds content posted to the server (piped documents,
+// render-content) when --max-file-size is 0.
+const maxPostedSize = 64 << 20
+
+// postedContentLimit is the largest request body taken as content.
+func (s *Server) postedContentLimit() int64 {
+	if limit := s.config.Renderer.MaxFileSize; limit > 0 {
+		return limit
+	}
+	return maxPostedSize
+}
+
+// postedContentError answers a request whose content couldn't be read:
+// 413 when it is over postedContentLimit, 400 otherwise.
+func (s *Server) postedContentError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("content is larger than %s", formatSize(s.postedContentLimit())), http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
 }
 
 func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
@@ -599,33 +2530,227 @@ func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(info)
 }
 
+// handleStatus reports the server's version and basic runtime details. Unlike
+// /api/version it doesn't contact GitHub, so it answers immediately.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.hub.mu.RLock()
+	files := len(s.hub.files)
+	s.hub.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StatusInfo{
+		Version:   Version,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Port:      s.port,
+		PID:       os.Getpid(),
+		Started:   s.started,
+		Files:     files,
+		AllowOpen: s.allowOpen,
+		Name:      instanceName,
+	})
+}
+
+// APIIndex is what GET / returns to clients that accept JSON rather than
+// HTML: the server's version, its main endpoints and the watched files.
+type APIIndex struct {
+	Name      string        `json:"name"`
+	Version   string        `json:"version"`
+	Instance  string        `json:"instance,omitempty"` // from start --name
+	Endpoints []APIEndpoint `json:"endpoints"`
+	Files     []WatchedFile `json:"files"` // without their HTML and source
+}
+
+// APIEndpoint describes one route of the HTTP API.
+type APIEndpoint struct {
+	Path        string   `json:"path"`
+	Methods     []string `json:"methods"`
+	Description string   `json:"description"`
+}
+
+// apiEndpoints lists the routes scripts are likely to want, for the API
+// index. The rest are documented in docs/server.md.
+var apiEndpoints = []APIEndpoint{
+	{"/api/watch", []string{"POST", "DELETE"}, "Add (?path=) or remove a file"},
+	{"/api/files", []string{"GET"}, "Watched files, or one file with ?path="},
+	{"/api/files/activate", []string{"POST"}, "Start watching a file for changes (?path=)"},
+	{"/api/files/deactivate", []string{"POST"}, "Stop watching a file for changes (?path=)"},
+	{"/api/files/refresh", []string{"POST"}, "Re-render one file (?path=) or all"},
+	{"/api/select", []string{"GET", "POST"}, "Switch browsers to a file (?path=)"},
+	{"/api/render", []string{"GET"}, "Rendered HTML of a file (?path=)"},
+	{"/api/content", []string{"GET"}, "Raw content of a file (?path=)"},
+	{"/api/render-content", []string{"POST"}, "HTML of posted {filename, content}, nothing registered"},
+	{"/api/linkcheck", []string{"GET"}, "Broken links of a markdown file (?path=)"},
+	{"/api/logs", []string{"GET"}, "Log entries"},
+	{"/api/status", []string{"GET"}, "Version, port and file count"},
+	{"/api/config", []string{"GET"}, "Settings the server runs with"},
+	{"/api/shutdown", []string{"POST"}, "Stop the server"},
+	{"/healthz", []string{"GET", "HEAD"}, "Liveness and readiness probe"},
+	{"/ws", []string{"GET"}, "WebSocket of file list and update messages"},
+}
+
+// handleAPIIndex answers GET / for clients that prefer JSON.
+func (s *Server) handleAPIIndex(w http.ResponseWriter, r *http.Request) {
+	files := s.hub.GetFiles()
+	for i := range files {
+		files[i].HTML = ""
+		files[i].Source = ""
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIIndex{
+		Name:      "livemd",
+		Version:   Version,
+		Instance:  instanceName,
+		Endpoints: apiEndpoints,
+		Files:     files,
+	})
+}
+
+// prefersJSON reports whether an Accept header ranks application/json above
+// text/html. Browsers list text/html first, curl sends */*, and neither
+// gets JSON.
+func prefersJSON(accept string) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(fields[0])) {
+		case "application/json":
+			jsonQ = q
+		case "text/html":
+			htmlQ = q
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// handleHealth answers liveness and readiness probes (GET /healthz): 200
+// "ok" while the server is serving, 503 once it is shutting down. Requests
+// are only served after startup has finished, so a 200 also means ready.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	select {
+	case <-s.hub.stop:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "shutting down\n")
+	default:
+		io.WriteString(w, "ok\n")
+	}
+}
+
+// handleConfig returns the settings the server is running with: port and
+// bind address, renderer and watcher settings. Sizes are in bytes and
+// durations in nanoseconds, as in ServerConfig.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.config)
+}
+
+// handleExtensions returns the extensions set with "livemd start --exts",
+// which "livemd add -r" uses when no --filter is given. The list is empty
+// when the server was started without --exts.
+func (s *Server) handleExtensions(w http.ResponseWriter, r *http.Request) {
+	exts := s.extensions
+	if exts == nil {
+		exts = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"extensions": exts})
+}
+
+// handleLanguages lists the Chroma lexers available for highlighting and
+// the filename and extension mappings getLexer applies before them, the
+// ones from the config file ("custom") first.
+func (s *Server) handleLanguages(w http.ResponseWriter, r *http.Request) {
+	custom := s.config.Renderer.Lexers
+	if custom == nil {
+		custom = map[string]string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"lexers":     lexers.Names(false),
+		"custom":     custom,
+		"extensions": lexerExtensions,
+		"files":      lexerFiles,
+	})
+}
+
 // State file persistence for watch list
 
 func getStateFilePath() string {
+	if home := livemdHome(); home != "" {
+		return filepath.Join(home, instanceFileName("livemd", ".state"))
+	}
 	if runtime.GOOS == "windows" {
 		appData := os.Getenv("APPDATA")
 		if appData == "" {
 			appData = os.Getenv("USERPROFILE")
 		}
-		return filepath.Join(appData, "livemd.state")
+		return filepath.Join(appData, instanceFileName("livemd", ".state"))
 	}
 	home, _ := os.UserHomeDir()
-	return filepath.Join(home, ".livemd.state")
+	return filepath.Join(home, instanceFileName(".livemd", ".state"))
 }
 
 type stateFile struct {
-	Files []string `json:"files"`
+	Files  []string          `json:"files"`
+	Labels map[string]string `json:"labels,omitempty"` // custom labels by path in Files
+	Tail   map[string]bool   `json:"tail,omitempty"`   // tail mode set unlike the default
+	Muted  []string          `json:"muted,omitempty"`  // muted paths in Files
+	// Options are the render options set by path in Files
+	Options map[string]FileOptions `json:"options,omitempty"`
 }
 
 func (h *Hub) saveState() {
 	h.mu.RLock()
 	paths := make([]string, 0, len(h.files))
-	for p := range h.files {
+	labels := make(map[string]string)
+	tail := make(map[string]bool)
+	var muted []string
+	options := make(map[string]FileOptions)
+	for p, f := range h.files {
+		if isStdinPath(p) {
+			continue // piped documents don't outlive the server
+		}
+		// Save symlinks as links so a retargeted link is followed on restore
+		if f.LinkPath != "" {
+			p = f.LinkPath
+		}
 		paths = append(paths, p)
+		if f.Label != "" {
+			labels[p] = f.Label
+		}
+		if f.Tail != isTailFile(f.Path) {
+			tail[p] = f.Tail
+		}
+		if f.Muted {
+			muted = append(muted, p)
+		}
+		if f.Options != nil {
+			options[p] = *f.Options
+		}
 	}
 	h.mu.RUnlock()
 
-	state := stateFile{Files: paths}
+	state := stateFile{Files: paths, Labels: labels, Tail: tail, Muted: muted, Options: options}
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return
@@ -644,12 +2769,36 @@ func (h *Hub) loadState() {
 		return
 	}
 
+	muted := make(map[string]bool, len(state.Muted))
+	for _, path := range state.Muted {
+		muted[path] = true
+	}
+
 	for _, path := range state.Files {
-		if _, err := os.Stat(path); err != nil {
+		if isRemotePath(path) {
+			// Remote URLs are fetched by AddFile; an unreachable one is skipped there
+		} else if _, err := os.Stat(path); err != nil {
 			continue // skip files that no longer exist
 		}
 		if err := h.AddFile(path); err != nil {
-			log.Printf("State restore: skipping %s: %v", filepath.Base(path), err)
+			h.logger.Warn(fmt.Sprintf("State restore: skipping %s: %v", filepath.Base(path), err))
+			continue
+		}
+		if label := state.Labels[path]; label != "" {
+			h.setLabel(path, label)
+		}
+		rerender := ""
+		if tail, ok := state.Tail[path]; ok {
+			rerender, _ = h.setTail(path, tail)
+		}
+		if opts, ok := state.Options[path]; ok && opts.Validate() == nil {
+			rerender, _ = h.setOptions(path, opts)
+		}
+		if rerender != "" {
+			h.refresh(rerender, false)
+		}
+		if muted[path] {
+			h.setMuted(path, true)
 		}
 	}
 
@@ -658,16 +2807,29 @@ func (h *Hub) loadState() {
 	}
 }
 
-func StartServer(port int) {
-	hub := NewHub()
+func StartServer(config ServerConfig) {
+	port := config.Port
+	hub := NewHub(config)
+	// Watcher and net/http errors go to the log rather than the console,
+	// which keeps the banner for the addresses
+	log.SetFlags(0)
+	log.SetOutput(logWriter{hub.logger})
 	go hub.Run()
+	if config.RefreshInterval > 0 {
+		go hub.pollActive(config.RefreshInterval)
+	}
 
 	// Restore previously watched files
 	hub.loadState()
 
 	s := &Server{
-		hub:  hub,
-		port: port,
+		hub:        hub,
+		port:       port,
+		extensions: config.Extensions,
+		allowOpen:  config.AllowOpen,
+		customCSS:  config.CustomCSS,
+		config:     config,
+		started:    time.Now(),
 	}
 
 	mux := http.NewServeMux()
@@ -675,17 +2837,52 @@ func StartServer(port int) {
 	// Serve index.html at root
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
-			http.NotFound(w, r)
+			notFound(w, r)
+			return
+		}
+		// Scripts asking for JSON get the API index instead of the page
+		w.Header().Add("Vary", "Accept")
+		if prefersJSON(r.Header.Get("Accept")) {
+			s.handleAPIIndex(w, r)
+			return
+		}
+		data, err := staticFiles.ReadFile("static/index.html")
+		if err != nil {
+			// Only happens when the binary was built without static/
+			s.hub.logger.Error(fmt.Sprintf("Can't load index.html: %v", err))
+			http.Error(w, "LiveMD frontend missing from this build (static/index.html not embedded)", http.StatusInternalServerError)
 			return
 		}
-		data, _ := staticFiles.ReadFile("static/index.html")
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.Write(data)
 	})
 
+	// Browsers request /favicon.ico regardless of the <link rel="icon">
+	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		data, _ := staticFiles.ReadFile("static/favicon.svg")
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Write(data)
+	})
+
+	// The --css stylesheet, linked after the built-in styles; empty without it
+	mux.HandleFunc("/custom.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		io.WriteString(w, s.customCSS)
+	})
+
 	// Serve static files
 	staticFS, _ := fs.Sub(staticFiles, "static")
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
+	fileServer := http.FileServer(http.FS(staticFS))
+	mux.Handle("/static/", http.StripPrefix("/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Unknown assets and directory listings get the 404 page
+		if info, err := fs.Stat(staticFS, strings.TrimSuffix(r.URL.Path, "/")); err != nil || info.IsDir() {
+			notFound(w, r)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})))
 
 	// WebSocket endpoint
 	mux.HandleFunc("/ws", s.handleWebSocket)
@@ -716,6 +2913,24 @@ func StartServer(port int) {
 		}
 		s.handleDeactivateFile(w, r)
 	})
+	mux.HandleFunc("/api/files/activate-all", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		count := s.hub.ActivateAll()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"activated": count})
+	})
+	mux.HandleFunc("/api/files/deactivate-all", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		count := s.hub.DeactivateAll()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"deactivated": count})
+	})
 	mux.HandleFunc("/api/files/remove-folder", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -739,9 +2954,121 @@ func StartServer(port int) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]int{"removed": count})
 	})
+	mux.HandleFunc("/api/files/label", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "Missing path parameter", http.StatusBadRequest)
+			return
+		}
+		if err := s.hub.SetLabel(path, r.URL.Query().Get("label")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/api/files/open-editor", func(w http.ResponseWriter, r *http.Request) {
+		s.handleOpen(w, r, "Open in editor", openInEditor)
+	})
+	mux.HandleFunc("/api/files/reveal", func(w http.ResponseWriter, r *http.Request) {
+		s.handleOpen(w, r, "Reveal in file manager", revealInFileManager)
+	})
+	mux.HandleFunc("/api/files/tail", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "Missing path parameter", http.StatusBadRequest)
+			return
+		}
+		// ?on=false turns tail mode off; anything else turns it on
+		tail := r.URL.Query().Get("on") != "false"
+		if err := s.hub.SetTail(path, tail); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	// The body is a FileOptions object; {} goes back to the server's settings
+	mux.HandleFunc("/api/files/options", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "Missing path parameter", http.StatusBadRequest)
+			return
+		}
+		var opts FileOptions
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&opts); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid options: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.hub.SetOptions(path, opts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/api/files/mute", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		path := r.URL.Query().Get("path")
+		if path == "" {
+			http.Error(w, "Missing path parameter", http.StatusBadRequest)
+			return
+		}
+		// ?on=false unmutes; anything else mutes
+		muted := r.URL.Query().Get("on") != "false"
+		if err := s.hub.SetMuted(path, muted); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/api/files/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Without a path, every file is refreshed
+		if path := r.URL.Query().Get("path"); path != "" {
+			if err := s.hub.RefreshFile(path); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		count := s.hub.RefreshAll()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"refreshed": count})
+	})
+	mux.HandleFunc("/api/select", s.handleSelect)
+	mux.HandleFunc("/api/render", s.handleRender)
+	mux.HandleFunc("/api/preview", s.handlePreview)
+	mux.HandleFunc("/api/render-content", s.handleRenderContent)
+	mux.HandleFunc("/api/content", s.handleContent)
+	mux.HandleFunc("/api/linkcheck", s.handleLinkCheck)
+	mux.HandleFunc("/api/stdin", s.handleStdin)
 	mux.HandleFunc("/api/logs", s.handleLogs)
 	mux.HandleFunc("/api/releases", s.handleReleases)
 	mux.HandleFunc("/api/version", s.handleVersion)
+	mux.HandleFunc("/api/extensions", s.handleExtensions)
+	mux.HandleFunc("/api/languages", s.handleLanguages)
+	mux.HandleFunc("/api/status", s.handleStatus)
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/api/config", s.handleConfig)
 	mux.HandleFunc("/api/remove", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -758,21 +3085,26 @@ func StartServer(port int) {
 		}()
 	})
 
+	origins := newOriginPolicy(config.AllowOrigins)
+	upgrader.CheckOrigin = origins.checkOrigin
+
 	s.server = &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Addr:    net.JoinHostPort(config.Bind, strconv.Itoa(port)),
+		Handler: originHandler(origins, gzipHandler(mux)),
 	}
 
-	// Check for updates in background on startup
-	go func() {
-		time.Sleep(2 * time.Second) // Wait for server to be ready
-		if Version != "dev" {
+	// Check for updates in background on startup. A failed check (e.g.
+	// GitHub unreachable) is silently ignored.
+	if config.CheckUpdates && Version != "dev" {
+		go func() {
+			time.Sleep(2 * time.Second) // Wait for server to be ready
 			info := CheckForUpdate()
 			if info.UpdateAvail {
+				fmt.Printf("  A new version %s is available, run 'livemd update'\n", info.Latest)
 				hub.logger.Info(fmt.Sprintf("Update available: %s (current: %s)", info.Latest, info.Current))
 			}
-		}
-	}()
+		}()
+	}
 
 	// Graceful shutdown on signals
 	sigChan := make(chan os.Signal, 1)
@@ -786,7 +3118,28 @@ func StartServer(port int) {
 		s.server.Shutdown(context.Background())
 	}()
 
-	if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
-		log.Fatalf("Server error: %v", err)
+	// Bind before reporting the server as started, so a port taken since
+	// the availability check fails here instead of leaving a dead lock file
+	ln, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		serverError(err)
+	}
+	if config.OnListening != nil {
+		if err := config.OnListening(); err != nil {
+			ln.Close()
+			serverError(err)
+		}
+	}
+
+	if err := s.server.Serve(ln); err != http.ErrServerClosed {
+		removeLockFile()
+		serverError(err)
 	}
 }
+
+// serverError reports an error that stops the server and exits. The log
+// package writes to the Logger by now, so it goes to stderr directly.
+func serverError(err error) {
+	fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+	os.Exit(1)
+}
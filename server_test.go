@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestHub starts a hub with config, keeping its state file in a
+// temporary home directory.
+func newTestHub(t *testing.T, config ServerConfig) *Hub {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	h := NewHub(config)
+	go h.Run()
+	t.Cleanup(h.Close)
+	return h
+}
+
+// writeTestFile creates a file under dir and returns its path.
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAddWatchedFileIsSkipped(t *testing.T) {
+	s := &Server{hub: newTestHub(t, ServerConfig{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/watch", s.handleAddFile)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	path := writeTestFile(t, t.TempDir(), "README.md", "# Hello\n")
+	if err := s.hub.AddFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"path": path})
+	resp, err := http.Post(ts.URL+"/api/watch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiErr := readAPIError(resp)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict || apiErr.Code != codeAlreadyRegistered {
+		t.Fatalf("re-adding: got %d %q, want %d %q", resp.StatusCode, apiErr.Code, http.StatusConflict, codeAlreadyRegistered)
+	}
+
+	other := writeTestFile(t, filepath.Dir(path), "other.md", "# Other\n")
+	added, skipped := addFiles([]string{path, other}, ts.URL, true, false)
+	if added != 1 || skipped != 1 {
+		t.Errorf("addFiles: added %d, skipped %d; want 1 and 1", added, skipped)
+	}
+}
+
+// chdir changes the working directory for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestPathSpellingsFindTheSameFile(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	path := NormalizePath(writeTestFile(t, dir, filepath.Join("docs", "README.md"), "# Docs\n"))
+	sep := string(filepath.Separator)
+
+	spellings := []string{
+		"." + sep + filepath.Join("docs", ".", "README.md"),
+		filepath.Join("docs", "README.md"),
+		filepath.Join("docs", "README.md") + sep,
+		"a" + sep + ".." + sep + filepath.Join("docs", "README.md"),
+	}
+	for _, spelling := range spellings {
+		t.Run(spelling, func(t *testing.T) {
+			if got := CleanPath(spelling); got != path {
+				t.Errorf("CleanPath = %q, want %q", got, path)
+			}
+			if !PathsEqual(spelling, path) {
+				t.Errorf("PathsEqual(%q, %q) = false", spelling, path)
+			}
+
+			h := newTestHub(t, ServerConfig{})
+			if err := h.AddFile(spelling); err != nil {
+				t.Fatalf("AddFile: %v", err)
+			}
+			if _, ok := h.files[path]; !ok || len(h.files) != 1 {
+				t.Fatalf("AddFile registered %v, want only %s", keys(h.files), path)
+			}
+			for _, other := range append(spellings, path) {
+				if err := h.AddFile(other); !errors.Is(err, errAlreadyRegistered) {
+					t.Errorf("AddFile(%q) after %q: got %v, want errAlreadyRegistered", other, spelling, err)
+				}
+			}
+
+			if err := h.ActivateFile(spelling); err != nil {
+				t.Fatalf("ActivateFile: %v", err)
+			}
+			if !h.files[path].Active {
+				t.Error("ActivateFile didn't activate the registered file")
+			}
+			if err := h.DeactivateFile(spelling); err != nil {
+				t.Fatalf("DeactivateFile: %v", err)
+			}
+			if h.files[path].Active {
+				t.Error("DeactivateFile didn't deactivate the registered file")
+			}
+
+			if err := h.RemoveFile(spelling); err != nil {
+				t.Fatalf("RemoveFile: %v", err)
+			}
+			if len(h.files) != 0 {
+				t.Errorf("RemoveFile left %v", keys(h.files))
+			}
+		})
+	}
+}
+
+// keys returns the paths of files, for error messages.
+func keys(files map[string]*WatchedFile) []string {
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	return paths
+}
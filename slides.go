@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// Presentation mode
+//
+// A markdown file whose front matter sets "mode: slides" is rendered as a
+// deck: each part between thematic breaks (---) becomes a slide, and the
+// browser shows one slide at a time with next/prev navigation.
+//
+//	---
+//	mode: slides
+//	---
+//	# Title slide
+//
+//	---
+//
+//	## Second slide
+
+// slidesSource returns the markdown after the front matter when content is
+// a slide deck, and false for regular documents.
+func slidesSource(content []byte) ([]byte, bool) {
+	meta, body, ok := splitFrontMatter(content)
+	if !ok {
+		return nil, false
+	}
+	for _, line := range strings.Split(meta, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found || !strings.EqualFold(strings.TrimSpace(key), "mode") {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		return body, strings.EqualFold(value, "slides")
+	}
+	return nil, false
+}
+
+// splitFrontMatter splits a leading "---" delimited block off content.
+func splitFrontMatter(content []byte) (string, []byte, bool) {
+	s := strings.ReplaceAll(string(content), "\r\n", "\n")
+	if !strings.HasPrefix(s, "---\n") {
+		return "", nil, false
+	}
+	rest := s[len("---\n"):]
+	end := strings.Index(rest, "\n---\n")
+	if end < 0 {
+		if !strings.HasSuffix(rest, "\n---") {
+			return "", nil, false
+		}
+		end = len(rest) - len("\n---")
+		return rest[:end], nil, true
+	}
+	return rest[:end], []byte(rest[end+len("\n---\n"):]), true
+}
+
+// renderSlides renders markdown with each top-level section between
+// thematic breaks wrapped in a <section class="slide">.
+func (r *Renderer) renderSlides(source []byte) (string, error) {
+	doc := r.md.Parser().Parse(text.NewReader(source))
+
+	var buf bytes.Buffer
+	buf.WriteString(`<div class="slides"><section class="slide">`)
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		if n.Kind() == ast.KindThematicBreak {
+			buf.WriteString("</section>\n<section class=\"slide\">")
+			continue
+		}
+		if err := r.md.Renderer().Render(&buf, source, n); err != nil {
+			return "", err
+		}
+	}
+	buf.WriteString("</section></div>\n")
+	return buf.String(), nil
+}
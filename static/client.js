@@ -14,16 +14,28 @@
     const contentHeaderFilename = document.getElementById('content-header-filename');
     const contentHeaderPath = document.getElementById('content-header-path');
     const contentHeaderChanged = document.getElementById('content-header-changed');
+    const renderError = document.getElementById('render-error');
+    const stalledBanner = document.getElementById('stalled-banner');
+    const stalledText = document.getElementById('stalled-text');
+    const stalledRetry = document.getElementById('stalled-retry');
 
     let ws;
     let reconnectDelay = 1000;
     const maxReconnectDelay = 10000;
+    // The server sends a heartbeat every 15s; a connection silent for
+    // longer than this is treated as lost, even if the socket hasn't noticed
+    const heartbeatTimeout = 40000;
+    let lastMessageAt = 0;
 
     let files = [];
     let logs = [];
     let activeFile = null;
     let collapsedFolders = new Set();
     let changelogLoaded = false;
+    let pendingLine = null; // line id (e.g. "L42") to scroll to once its file is shown
+    let allowOpen = false; // server started with --allow-open
+    let currentSlide = 0; // slide shown when the active file is a slide deck
+    let showPreviewSource = false; // diagram and HTML previews show their source instead
 
     // Tab switching
     document.querySelectorAll('.tabs li').forEach(li => {
@@ -52,6 +64,24 @@
         checkForUpdates();
     });
 
+    // Show the server's version right away; the update check contacts
+    // GitHub and may be slow or fail
+    function loadStatus() {
+        fetch('/api/status')
+            .then(r => r.json())
+            .then(info => {
+                versionLabel.textContent = 'livemd ' + info.version;
+                versionLabel.title = info.os + '/' + info.arch + ', started ' + formatShortDateTime(info.started);
+                if (allowOpen !== !!info.allowOpen) {
+                    allowOpen = !!info.allowOpen;
+                    renderFileList();
+                }
+            })
+            .catch(err => {
+                console.error('Failed to load status:', err);
+            });
+    }
+
     function checkForUpdates() {
         fetch('/api/version')
             .then(r => r.json())
@@ -183,6 +213,48 @@
         return `${month}-${day} ${hours}:${mins}`;
     }
 
+    // formatAgo describes how long ago a time was, e.g. "3s ago" or "5m ago"
+    function formatAgo(isoString) {
+        const secs = Math.max(0, Math.floor((Date.now() - new Date(isoString).getTime()) / 1000));
+        if (secs < 5) return 'just now';
+        if (secs < 60) return secs + 's ago';
+        if (secs < 3600) return Math.floor(secs / 60) + 'm ago';
+        if (secs < 86400) return Math.floor(secs / 3600) + 'h ago';
+        return Math.floor(secs / 86400) + 'd ago';
+    }
+
+    // fileLabel returns the name shown for a file: its custom label, if set
+    function fileLabel(file) {
+        return file.label || file.name;
+    }
+
+    // editLabel asks for a new display label; an empty one restores the file name
+    function editLabel(path) {
+        const file = files.find(f => f.path === path);
+        if (!file) return;
+        const label = prompt('Label for ' + file.name + ' (empty to reset):', file.label || '');
+        if (label === null) return;
+        fetch('/api/files/label?path=' + encodeURIComponent(path) + '&label=' + encodeURIComponent(label), {
+            method: 'POST'
+        }).catch(err => {
+            console.error('Failed to set label:', err);
+        });
+    }
+
+    function formatSize(bytes) {
+        if (bytes < 1024) return bytes + ' B';
+        if (bytes < 1024 * 1024) return (bytes / 1024).toFixed(1) + ' KB';
+        return (bytes / (1024 * 1024)).toFixed(1) + ' MB';
+    }
+
+    // formatFileStats describes a file's size and line count, e.g. "2.1 KB, 64 lines"
+    function formatFileStats(file) {
+        if (!file.size && !file.lines) return '';
+        let text = formatSize(file.size || 0);
+        if (file.lines) text += ', ' + file.lines + (file.lines === 1 ? ' line' : ' lines');
+        return text;
+    }
+
     function findCommonPrefix(paths) {
         if (paths.length === 0) return '';
         if (paths.length === 1) {
@@ -232,7 +304,8 @@
                 current = current.children[part];
             }
 
-            current.files.push({ ...file, displayName: fileName });
+            // Symlinks keep the link's name; a custom label replaces it
+            current.files.push({ ...file, displayName: fileLabel(file) || fileName });
         }
 
         return tree;
@@ -273,16 +346,20 @@
         for (const file of sortedFiles) {
             const isDeleted = file.deleted;
             const deletedClass = isDeleted ? 'deleted' : '';
-            const stateClass = file.active ? 'watching' : 'registered';
-            const iconClass = getFileIconClass(file.displayName);
+            const stateClass = (file.active ? 'watching' : 'registered') + (file.muted ? ' muted' : '') + (file.stalled ? ' stalled' : '') + (file.permissionDenied ? ' denied' : '') + (file.status === 'pending' ? ' pending' : '');
+            const iconClass = getFileIconClass(file.name || file.displayName);
             const iconHtml = iconClass ? `<i class="${iconClass}"></i>` : '<span class="file-icon-default">&#9679;</span>';
+            const openHtml = allowOpen && !isDeleted && !/^(https?:\/\/|stdin:)/.test(file.path) ? `
+                    <button class="file-open" data-action="open-editor" data-path="${escapeHtml(file.path)}" title="Open in editor">&#9998;</button>
+                    <button class="file-open" data-action="reveal" data-path="${escapeHtml(file.path)}" title="Show in file manager">&#128193;</button>` : '';
 
             html += `
-                <div class="file-item tree-file ${file.path === activeFile ? 'active' : ''} ${stateClass} ${deletedClass}" data-path="${escapeHtml(file.path)}" style="padding-left: ${indent}px">
+                <div class="file-item tree-file kind-${escapeHtml(file.kind || 'code')} ${file.path === activeFile ? 'active' : ''} ${stateClass} ${deletedClass}" data-path="${escapeHtml(file.path)}" style="padding-left: ${indent}px">
                     <button class="file-remove" data-path="${escapeHtml(file.path)}" title="Remove from watch">&#10005;</button>
+                    <button class="file-mute" data-path="${escapeHtml(file.path)}" data-muted="${file.muted ? 'true' : 'false'}" title="${file.muted ? 'Unmute' : 'Mute: changes never switch the view to this file'}">${file.muted ? '&#128263;' : '&#128264;'}</button>${openHtml}
                     <span class="file-icon">${iconHtml}</span>
                     <div class="file-info">
-                        <div class="file-name" title="${escapeHtml(file.path)}">${isDeleted ? '<span class="has-text-danger">' + escapeHtml(file.displayName) + '</span>' : escapeHtml(file.displayName)}</div>
+                        <div class="file-name" title="${escapeHtml(file.path + (formatFileStats(file) ? '\n' + formatFileStats(file) : ''))}">${isDeleted ? '<span class="has-text-danger">' + escapeHtml(file.displayName) + '</span>' : escapeHtml(file.displayName)}${file.status === 'pending' ? '<span class="render-spinner" title="Rendering"></span>' : ''}${file.renderError || file.watchError || file.permissionDenied ? `<span class="render-error-mark" title="${escapeHtml(file.renderError || (file.watchError ? 'Not watched: ' + file.watchError : 'Permission denied'))}">!</span>` : ''}</div>
                     </div>
                 </div>
             `;
@@ -342,9 +419,13 @@
 
         fileList.querySelectorAll('.tree-file').forEach(el => {
             el.addEventListener('click', (e) => {
-                if (e.target.classList.contains('file-remove')) return;
+                if (e.target.closest('button')) return;
                 selectFile(el.dataset.path);
             });
+            el.addEventListener('dblclick', (e) => {
+                if (e.target.closest('button')) return;
+                editLabel(el.dataset.path);
+            });
         });
 
         fileList.querySelectorAll('.file-remove').forEach(btn => {
@@ -354,6 +435,20 @@
             });
         });
 
+        fileList.querySelectorAll('.file-mute').forEach(btn => {
+            btn.addEventListener('click', (e) => {
+                e.stopPropagation();
+                muteFile(btn.dataset.path, btn.dataset.muted !== 'true');
+            });
+        });
+
+        fileList.querySelectorAll('.file-open').forEach(btn => {
+            btn.addEventListener('click', (e) => {
+                e.stopPropagation();
+                openFile(btn.dataset.action, btn.dataset.path);
+            });
+        });
+
         fileList.querySelectorAll('.folder-toggle').forEach(el => {
             el.addEventListener('click', (e) => {
                 e.stopPropagation();
@@ -383,6 +478,26 @@
         });
     }
 
+    // muteFile mutes or unmutes a file; the new state comes back with the
+    // file list
+    function muteFile(path, muted) {
+        fetch('/api/files/mute?path=' + encodeURIComponent(path) + '&on=' + muted, {
+            method: 'POST'
+        }).catch(err => {
+            console.error('Failed to mute file:', err);
+        });
+    }
+
+    // openFile asks the server to open a file in its editor or file manager
+    // (action is "open-editor" or "reveal")
+    function openFile(action, path) {
+        fetch('/api/files/' + action + '?path=' + encodeURIComponent(path), {
+            method: 'POST'
+        }).catch(err => {
+            console.error('Failed to open file:', err);
+        });
+    }
+
     function removeFolder(path) {
         fetch('/api/files/remove-folder?path=' + encodeURIComponent(path), {
             method: 'POST'
@@ -419,16 +534,83 @@
 
     function updateContentHeader(file) {
         if (file) {
-            contentHeaderFilename.textContent = file.name;
+            contentHeaderFilename.textContent = fileLabel(file);
             contentHeaderPath.textContent = file.path;
-            contentHeaderChanged.textContent = file.lastChange ? 'Changed: ' + formatShortDateTime(file.lastChange) : '';
+            updateChangedText(file);
+            document.title = fileLabel(file) + ' - LiveMD';
+            content.dataset.kind = file.kind || '';
+            showRenderError(file.renderError);
+            showStalled(file);
         } else {
             contentHeaderFilename.textContent = 'No file selected';
             contentHeaderPath.textContent = '';
             contentHeaderChanged.textContent = '';
+            contentHeaderChanged.title = '';
+            document.title = 'LiveMD';
+            delete content.dataset.kind;
+            showRenderError(null);
+        }
+    }
+
+    // updateChangedText shows the file's stats and how long ago it last
+    // changed, e.g. "2.1 KB, 64 lines · updated 3s ago"
+    function updateChangedText(file) {
+        const stats = formatFileStats(file);
+        const changed = file.lastChange ? 'updated ' + formatAgo(file.lastChange) : '';
+        contentHeaderChanged.textContent = stats && changed ? stats + ' \u00b7 ' + changed : stats || changed;
+        contentHeaderChanged.title = file.lastChange ? 'Changed: ' + formatShortDateTime(file.lastChange) : '';
+    }
+
+    // Keep the "updated ... ago" text current
+    setInterval(() => {
+        const file = activeFile && files.find(f => f.path === activeFile);
+        if (file && !file.deleted) updateChangedText(file);
+    }, 1000);
+
+    // showRenderError shows a banner above the content when the active file
+    // failed to render; the content below is then the last good render.
+    function showRenderError(message) {
+        if (message) {
+            renderError.textContent = 'Failed to render: ' + message;
+            renderError.classList.remove('is-hidden');
+        } else {
+            renderError.textContent = '';
+            renderError.classList.add('is-hidden');
         }
     }
 
+    // showStalled warns when the active file's watcher stopped, so the view
+    // no longer follows the file, and offers to watch it again. A file that
+    // became unreadable gets the banner without the button: it is still
+    // watched and shows again once readable. A file that isn't watched is a
+    // preview, rendered when selected, and gets the button to watch it.
+    function showStalled(file) {
+        if (file && file.stalled) {
+            stalledText.textContent = 'Live reload stopped for ' + fileLabel(file) +
+                (file.watchError ? ' (' + file.watchError + ')' : '') + '.';
+            stalledRetry.textContent = 'Watch again';
+            stalledRetry.classList.remove('is-hidden');
+            stalledBanner.classList.remove('is-hidden');
+        } else if (file && !file.active && !file.deleted) {
+            stalledText.textContent = 'Preview of ' + fileLabel(file) +
+                ' as it was when selected; it is not watched, so changes don\'t show.';
+            stalledRetry.textContent = 'Watch';
+            stalledRetry.classList.remove('is-hidden');
+            stalledBanner.classList.remove('is-hidden');
+        } else if (file && file.permissionDenied) {
+            stalledText.textContent = 'Permission denied reading ' + fileLabel(file) +
+                '. Showing the last readable version until it can be read again.';
+            stalledRetry.classList.add('is-hidden');
+            stalledBanner.classList.remove('is-hidden');
+        } else {
+            stalledBanner.classList.add('is-hidden');
+        }
+    }
+
+    stalledRetry.addEventListener('click', () => {
+        if (activeFile) activateFile(activeFile);
+    });
+
     function selectFile(path) {
         const file = files.find(f => f.path === path);
         if (file && file.deleted) return; // Can't select deleted files
@@ -436,144 +618,524 @@
         const previousFile = activeFile;
         activeFile = path;
         renderFileList();
+        if (path !== previousFile) currentSlide = 0;
 
         if (file && file.html) {
-            content.innerHTML = file.html;
-            document.title = file.name + ' - LiveMD';
+            showFileContent(file);
+        } else if (file) {
+            // File lists leave out the HTML of files not shown
+            content.innerHTML = '<div class="empty-state"><p>Loading ' + escapeHtml(fileLabel(file)) + '...</p></div>';
             updateContentHeader(file);
+            if (file.active && file.streamed) {
+                fetchStreamed(file);
+            } else if (file.active) {
+                fetchFile(path);
+            }
         }
+        // A file that isn't watched is shown freshly rendered, without
+        // starting a watcher; the banner offers to watch it
+        if (file && !file.active) previewFile(path);
 
-        if (path && path !== previousFile) {
-            activateFile(path);
+        if (path && parseHash().file !== path) {
+            history.replaceState(null, '', '#file=' + encodeURIComponent(path));
         }
+    }
+
+    // showFileContent shows a file's HTML in the content area
+    function showFileContent(file) {
+        content.innerHTML = fileContentHtml(file);
+        setupSlides();
+        setupPreviews();
+        updateContentHeader(file);
+        if (file.tail && !pendingLine) scrollToEnd();
+        scrollToPendingLine();
+    }
 
-        if (previousFile && previousFile !== path) {
-            deactivateFile(previousFile);
+    // fileContentHtml returns what the content area shows for a file: its
+    // HTML, or the source beside it when the server sends source
+    // (start --with-source)
+    function fileContentHtml(file) {
+        if (!file.source) return file.html;
+        return '<div class="split-view">' +
+            '<pre class="split-source">' + escapeHtml(file.source) + '</pre>' +
+            '<div class="split-rendered">' + file.html + '</div>' +
+            '</div>';
+    }
+
+    // setupSlides adds next/prev navigation to a slide deck (a markdown file
+    // with "mode: slides" front matter) and shows the current slide, which is
+    // kept across live reloads
+    function setupSlides() {
+        const deck = content.querySelector('.slides');
+        if (!deck) return;
+        const nav = document.createElement('div');
+        nav.className = 'slide-nav';
+        nav.innerHTML = '<button class="button is-small" data-step="-1" title="Previous slide">&#8592;</button>' +
+            '<span class="slide-count"></span>' +
+            '<button class="button is-small" data-step="1" title="Next slide">&#8594;</button>';
+        nav.querySelectorAll('button').forEach(btn => {
+            btn.addEventListener('click', () => showSlide(currentSlide + Number(btn.dataset.step)));
+        });
+        deck.appendChild(nav);
+        showSlide(currentSlide);
+    }
+
+    function showSlide(index) {
+        const slides = content.querySelectorAll('.slides > .slide');
+        if (slides.length === 0) return;
+        currentSlide = Math.max(0, Math.min(index, slides.length - 1));
+        slides.forEach((slide, i) => slide.classList.toggle('is-current', i === currentSlide));
+        content.querySelector('.slide-count').textContent = (currentSlide + 1) + ' / ' + slides.length;
+    }
+
+    // Arrow keys, Page Up/Down and space page through a slide deck
+    document.addEventListener('keydown', (e) => {
+        if (!content.querySelector('.slides') || e.target.closest('input, textarea')) return;
+        if (e.key === 'ArrowRight' || e.key === 'PageDown' || e.key === ' ') {
+            showSlide(currentSlide + 1);
+        } else if (e.key === 'ArrowLeft' || e.key === 'PageUp') {
+            showSlide(currentSlide - 1);
+        } else {
+            return;
+        }
+        e.preventDefault();
+    });
+
+    // Diagram renderers, loaded from the CDN the first time a diagram file
+    // is shown
+    const diagramRenderers = {
+        mermaid: {
+            url: 'https://cdn.jsdelivr.net/npm/mermaid@10/dist/mermaid.esm.min.mjs',
+            init: (mod) => mod.default.initialize({ startOnLoad: false }),
+            render: async (mod, code, view) => {
+                const { svg } = await mod.default.render('diagram-' + Date.now(), code);
+                view.innerHTML = svg;
+            }
+        },
+        dot: {
+            url: 'https://cdn.jsdelivr.net/npm/@viz-js/viz@3/lib/viz-standalone.mjs',
+            render: async (mod, code, view) => {
+                const viz = await mod.instance();
+                view.replaceChildren(viz.renderSVGElement(code));
+            }
         }
+    };
+    const diagramModules = {};
+
+    function loadDiagramRenderer(kind) {
+        if (!diagramModules[kind]) {
+            const renderer = diagramRenderers[kind];
+            diagramModules[kind] = import(renderer.url).then(mod => {
+                if (renderer.init) renderer.init(mod);
+                return mod;
+            });
+            // Let a failed load be retried on the next render
+            diagramModules[kind].catch(() => delete diagramModules[kind]);
+        }
+        return diagramModules[kind];
     }
 
-    function activateFile(path) {
-        fetch('/api/files/activate?path=' + encodeURIComponent(path), {
-            method: 'POST'
-        }).catch(err => {
-            console.error('Failed to activate file:', err);
+    // setupPreviews wires the toggle between a preview (a drawn diagram or a
+    // rendered HTML page) and its highlighted source, and draws diagrams
+    function setupPreviews(roots) {
+        roots = roots || [content];
+        queryAll(roots, '.preview-toggle').forEach(toggle => {
+            const el = toggle.closest('.diagram, .html-preview, .structured');
+            const view = el.querySelector('.preview-view');
+            const source = el.querySelector('.preview-source');
+            const label = el.classList.contains('diagram') ? 'diagram'
+                : el.classList.contains('structured') ? 'tree' : 'page';
+            const apply = () => {
+                view.classList.toggle('is-hidden', showPreviewSource);
+                source.classList.toggle('is-hidden', !showPreviewSource);
+                toggle.textContent = showPreviewSource ? 'Show ' + label : 'Show source';
+            };
+            toggle.addEventListener('click', () => {
+                showPreviewSource = !showPreviewSource;
+                apply();
+            });
+            apply();
         });
+        setupDiagrams(roots);
     }
 
-    function deactivateFile(path) {
-        fetch('/api/files/deactivate?path=' + encodeURIComponent(path), {
-            method: 'POST'
-        }).catch(err => {
-            console.error('Failed to deactivate file:', err);
+    // queryAll finds the elements matching selector in and among roots
+    function queryAll(roots, selector) {
+        const found = [];
+        roots.forEach(root => {
+            if (root !== content && root.matches(selector)) found.push(root);
+            found.push(...root.querySelectorAll(selector));
         });
+        return found;
     }
 
-    function connect() {
-        const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        ws = new WebSocket(`${protocol}//${window.location.host}/ws`);
+    // setupDiagrams draws diagram files (.mmd, .dot, ...) in the browser
+    function setupDiagrams(roots) {
+        queryAll(roots, '.diagram').forEach(el => {
+            const kind = el.dataset.diagram;
+            if (!diagramRenderers[kind]) return; // drawn by the server
+            const view = el.querySelector('.diagram-view');
+            const code = el.querySelector('.diagram-code').textContent;
+
+            loadDiagramRenderer(kind)
+                .then(mod => diagramRenderers[kind].render(mod, code, view))
+                .catch(err => {
+                    view.innerHTML = '<div class="diagram-error">Failed to render diagram: ' +
+                        escapeHtml(err.message || String(err)) + '</div>';
+                });
+        });
+    }
 
-        ws.onopen = function() {
-            status.textContent = 'live';
-            status.className = 'tag is-success is-light';
-            reconnectDelay = 1000;
-            // Check version on connect
-            checkForUpdates();
-        };
+    // patchContent replaces the changed top-level blocks of the shown file in
+    // place, so the rest of the page isn't redrawn. A block is an element and
+    // the nodes after it up to the next element; the first block also holds
+    // what precedes it. Returns false when the page doesn't match the patch
+    // and has to be redrawn whole.
+    function patchContent(patch) {
+        if (content.querySelector('.slides, .split-view')) return false;
+        const blocks = Array.from(content.children);
+        if (blocks.length !== patch.blocks) return false;
+
+        const end = patch.start + patch.remove;
+        const next = blocks[end] || null;
+        for (let i = patch.start; i < end; i++) {
+            if (i === 0) {
+                while (content.firstChild !== blocks[0]) content.removeChild(content.firstChild);
+            }
+            let node = blocks[i].nextSibling;
+            while (node && node !== next && node.nodeType !== Node.ELEMENT_NODE) {
+                const following = node.nextSibling;
+                node.remove();
+                node = following;
+            }
+            blocks[i].remove();
+        }
 
-        ws.onmessage = function(event) {
-            const data = JSON.parse(event.data);
+        const template = document.createElement('template');
+        template.innerHTML = patch.html;
+        const inserted = Array.from(template.content.children);
+        content.insertBefore(template.content, next);
+        setupPreviews(inserted);
+        return true;
+    }
 
-            switch (data.type) {
-                case 'files':
-                    files = data.files || [];
-                    renderFileList();
+    // fetchFile takes a file whole from the server, when it is shown but
+    // came without HTML in the file list, or when an update can't be applied
+    // to the copy this page holds
+    function fetchFile(path) {
+        fetch('/api/files?path=' + encodeURIComponent(path))
+            .then(r => {
+                if (!r.ok) throw new Error(r.statusText);
+                return r.json();
+            })
+            .then(file => handleMessage({ type: 'update', file: file }))
+            .catch(err => console.error('Failed to fetch ' + path + ':', err));
+    }
 
-                    if (!activeFile && files.length > 0) {
-                        const firstNonDeleted = files.find(f => !f.deleted);
-                        if (firstNonDeleted) selectFile(firstNonDeleted.path);
-                    } else if (activeFile) {
-                        const file = files.find(f => f.path === activeFile);
-                        if (file && file.html && !file.deleted) {
-                            content.innerHTML = file.html;
-                            updateContentHeader(file);
-                        } else if (file && file.deleted) {
-                            content.innerHTML = `
-                                <div class="welcome">
-                                    <h1 class="has-text-danger">File Deleted</h1>
-                                    <p>${escapeHtml(file.name)} has been deleted from disk.</p>
-                                </div>
-                            `;
-                            updateContentHeader(null);
-                        }
+    // mergeFileList keeps the HTML this page holds for files that a new list
+    // sends without it, as long as they haven't been rendered again since
+    function mergeFileList(list) {
+        const known = new Map(files.map(f => [f.path, f]));
+        list.forEach(f => {
+            const old = known.get(f.path);
+            if (!f.html && old && old.html && old.revision === f.revision) {
+                f.html = old.html;
+                f.source = old.source;
+            }
+        });
+        return list;
+    }
+
+    // scrollToEnd follows the end of a file in tail mode, like tail -f
+    function scrollToEnd() {
+        content.scrollTop = content.scrollHeight;
+    }
+
+    // fetchStreamed takes the HTML of a large code file, which the server
+    // leaves out of its messages, from /api/render, where it is streamed as
+    // it is highlighted. It is shown if the file is still selected and
+    // hasn't been rendered again meanwhile.
+    function fetchStreamed(file) {
+        const path = file.path;
+        const revision = file.revision;
+        fetch('/api/render?path=' + encodeURIComponent(path))
+            .then(r => {
+                if (!r.ok) throw new Error(r.statusText);
+                return r.text();
+            })
+            .then(html => {
+                const known = files.find(f => f.path === path);
+                if (!known || known.revision !== revision) return;
+                known.html = html;
+                if (path !== activeFile) return;
+                const keepScroll = !known.tail && !pendingLine;
+                const scrollY = window.scrollY;
+                showFileContent(known);
+                if (keepScroll) window.scrollTo(0, scrollY);
+            })
+            .catch(err => console.error('Failed to fetch ' + path + ':', err));
+    }
+
+    // previewFile renders a file that isn't watched through /api/preview,
+    // which changes nothing on the server, and shows it if it is still
+    // selected. The HTML is kept with the file until it is rendered again.
+    function previewFile(path) {
+        fetch('/api/preview?path=' + encodeURIComponent(path))
+            .then(r => {
+                if (!r.ok) throw new Error(r.statusText);
+                return r.text();
+            })
+            .then(html => {
+                const file = files.find(f => f.path === path);
+                // Watched meanwhile: its updates show it
+                if (!file || file.active) return;
+                file.html = html;
+                file.source = ''; // the preview has no source to go beside it
+                if (path === activeFile) showFileContent(file);
+            })
+            .catch(err => console.error('Failed to preview ' + path + ':', err));
+    }
+
+    // activateFile starts watching a file, over the WebSocket when it's open
+    // and through the HTTP API otherwise
+    function activateFile(path) {
+        setWatching('activate', path);
+    }
+
+    function setWatching(action, path) {
+        if (ws && ws.readyState === WebSocket.OPEN) {
+            ws.send(JSON.stringify({ type: action, path: path }));
+            return;
+        }
+        fetch('/api/files/' + action + '?path=' + encodeURIComponent(path), {
+            method: 'POST'
+        }).catch(err => {
+            console.error('Failed to ' + action + ' file:', err);
+        });
+    }
+
+    // handleMessage applies a message from the server
+    function handleMessage(data) {
+        switch (data.type) {
+            case 'heartbeat':
+                break;
+
+            case 'files':
+                files = mergeFileList(data.files || []);
+                renderFileList();
+
+                const linked = !activeFile && fileFromHash();
+                if (linked) {
+                    selectFile(linked.path);
+                } else if (!activeFile && files.length > 0) {
+                    const firstNonDeleted = files.find(f => !f.deleted && !f.muted) || files.find(f => !f.deleted);
+                    if (firstNonDeleted) selectFile(firstNonDeleted.path);
+                } else if (activeFile) {
+                    const file = files.find(f => f.path === activeFile);
+                    if (file && file.html && !file.deleted) {
+                        content.innerHTML = fileContentHtml(file);
+                        setupSlides();
+                        setupPreviews();
+                        updateContentHeader(file);
+                    } else if (file && !file.deleted && file.status !== 'pending') {
+                        // A pending file is sent again once rendered
+                        if (file.active && file.streamed) fetchStreamed(file);
+                        else if (file.active) fetchFile(file.path);
+                        else previewFile(file.path);
+                    } else if (file && file.deleted) {
+                        content.innerHTML = `
+                            <div class="welcome">
+                                <h1 class="has-text-danger">File Deleted</h1>
+                                <p>${escapeHtml(file.name)} has been deleted from disk.</p>
+                            </div>
+                        `;
+                        updateContentHeader(null);
                     }
-                    break;
+                }
+                break;
+
+            case 'logs':
+                logs = data.logs || [];
+                renderLogList();
+                break;
 
-                case 'logs':
-                    logs = data.logs || [];
+            case 'log':
+                if (data.log) {
+                    logs.push(data.log);
+                    if (logs.length > 100) {
+                        logs = logs.slice(-100);
+                    }
                     renderLogList();
-                    break;
+                }
+                break;
 
-                case 'log':
-                    if (data.log) {
-                        logs.push(data.log);
-                        if (logs.length > 100) {
-                            logs = logs.slice(-100);
+            case 'update':
+                if (data.file) {
+                    const idx = files.findIndex(f => f.path === data.file.path);
+                    if (data.patch) {
+                        // Only the changed blocks were sent (start --partial-updates)
+                        const known = files[idx];
+                        if (known && known.revision === data.file.revision) break;
+                        if (known && known.html && known.revision === data.patch.base) {
+                            data.file.html = known.html.slice(0, data.patch.offset) + data.patch.html +
+                                known.html.slice(data.patch.offset + data.patch.length);
+                        } else if (data.file.path === activeFile) {
+                            fetchFile(data.file.path);
+                            break;
                         }
-                        renderLogList();
+                        // Otherwise the file is kept without HTML until it is shown
                     }
-                    break;
+                    if (idx >= 0) {
+                        files[idx] = data.file;
+                    } else {
+                        files.push(data.file);
+                    }
+                    renderFileList();
 
-                case 'update':
-                    if (data.file) {
-                        const idx = files.findIndex(f => f.path === data.file.path);
-                        if (idx >= 0) {
-                            files[idx] = data.file;
+                    if (data.file.streamed && !data.file.html) {
+                        // Large code files come without HTML; the old
+                        // render stays shown until the new one streams in
+                        if (data.file.path === activeFile) fetchStreamed(data.file);
+                        break;
+                    }
+                    if (data.file.path === activeFile && data.patch && patchContent(data.patch)) {
+                        updateContentHeader(data.file);
+                    } else if (data.file.path === activeFile) {
+                        const scrollY = window.scrollY;
+                        content.innerHTML = fileContentHtml(data.file);
+                        setupSlides();
+                        setupPreviews();
+                        if (data.file.tail) {
+                            scrollToEnd();
                         } else {
-                            files.push(data.file);
+                            window.scrollTo(0, scrollY);
                         }
-                        renderFileList();
+                        updateContentHeader(data.file);
+                    }
+                }
+                break;
 
-                        if (data.file.path === activeFile) {
-                            const scrollY = window.scrollY;
-                            content.innerHTML = data.file.html;
-                            window.scrollTo(0, scrollY);
+            case 'touch':
+                // Saved without changes; only the timestamp moved
+                if (data.file) {
+                    const touched = files.find(f => f.path === data.file.path);
+                    if (touched) {
+                        touched.lastChange = data.file.lastChange;
+                        if (touched.status !== data.file.status) {
+                            touched.status = data.file.status;
+                            renderFileList();
                         }
+                        if (touched.path === activeFile) updateChangedText(touched);
                     }
-                    break;
+                }
+                break;
 
-                case 'removed':
-                    files = files.filter(f => f.path !== data.path);
-                    renderFileList();
+            case 'status':
+                // A file started or finished rendering; the sidebar shows it
+                if (data.file) {
+                    const known = files.find(f => f.path === data.file.path);
+                    if (known && known.status !== data.file.status) {
+                        known.status = data.file.status;
+                        renderFileList();
+                    }
+                }
+                break;
 
-                    if (data.path === activeFile) {
-                        activeFile = null;
-                        const remaining = files.filter(f => !f.deleted);
-                        if (remaining.length > 0) {
-                            selectFile(remaining[0].path);
-                        } else {
-                            content.innerHTML = `
-                                <div class="welcome">
-                                    <h1>LiveMD</h1>
-                                    <p>Add a markdown file to get started:</p>
-                                    <pre><code>livemd add README.md</code></pre>
-                                </div>
-                            `;
-                            document.title = 'LiveMD';
-                            updateContentHeader(null);
-                        }
+            case 'stalled':
+            case 'denied':
+                // The file list follows; warn right away for the shown file
+                if (data.file) {
+                    const i = files.findIndex(f => f.path === data.file.path);
+                    if (i >= 0) files[i] = Object.assign({}, files[i], data.file, { html: files[i].html });
+                    if (data.file.path === activeFile) showStalled(data.file);
+                }
+                break;
+
+            case 'select':
+                // Another client or a script picked the file to show
+                // Muted files never take over the view
+                if (data.path && data.path !== activeFile && files.some(f => f.path === data.path && !f.muted)) {
+                    selectFile(data.path);
+                }
+                break;
+
+            case 'removed':
+                files = files.filter(f => f.path !== data.path);
+                renderFileList();
+
+                if (data.path === activeFile) {
+                    activeFile = null;
+                    const remaining = files.filter(f => !f.deleted);
+                    if (remaining.length > 0) {
+                        selectFile(remaining[0].path);
+                    } else {
+                        content.innerHTML = `
+                            <div class="welcome">
+                                <h1>LiveMD</h1>
+                                <p>Add a markdown file to get started:</p>
+                                <pre><code>livemd add README.md</code></pre>
+                            </div>
+                        `;
+                        updateContentHeader(null);
                     }
-                    break;
-            }
+                }
+                break;
+        }
+    }
+
+    // reconnecting shows why the connection is gone and connects again after
+    // a growing delay
+    function reconnecting(text, color, reason) {
+        status.textContent = text;
+        status.className = 'tag is-light ' + color;
+        status.title = reason || '';
+
+        setTimeout(function() {
+            reconnectDelay = Math.min(reconnectDelay * 1.5, maxReconnectDelay);
+            connect();
+        }, reconnectDelay);
+    }
+
+    // A connection that stopped getting heartbeats is dropped without
+    // waiting for the socket to time out
+    setInterval(() => {
+        if (!ws || ws.readyState !== WebSocket.OPEN || Date.now() - lastMessageAt < heartbeatTimeout) return;
+        const stale = ws;
+        stale.onclose = null;
+        stale.onerror = null;
+        stale.onmessage = null;
+        stale.close();
+        reconnecting('disconnected, reconnecting...', 'is-danger', 'no heartbeat from the server');
+    }, 5000);
+
+    function connect() {
+        const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+        ws = new WebSocket(`${protocol}//${window.location.host}/ws`);
+
+        ws.onopen = function() {
+            status.textContent = 'live';
+            status.className = 'tag is-success is-light';
+            status.title = '';
+            reconnectDelay = 1000;
+            lastMessageAt = Date.now();
+            // Check version on connect
+            loadStatus();
+            checkForUpdates();
         };
 
-        ws.onclose = function() {
-            status.textContent = 'disconnected';
-            status.className = 'tag is-danger is-light';
+        ws.onmessage = function(event) {
+            lastMessageAt = Date.now();
+            handleMessage(JSON.parse(event.data));
+        };
 
-            setTimeout(function() {
-                reconnectDelay = Math.min(reconnectDelay * 1.5, maxReconnectDelay);
-                connect();
-            }, reconnectDelay);
+        ws.onclose = function(event) {
+            // The server closes with 1001 (going away) when it shuts down,
+            // e.g. for a restart; anything else is a dropped connection
+            if (event.code === 1001) {
+                reconnecting('server stopped, reconnecting...', 'is-warning', event.reason);
+            } else {
+                reconnecting('disconnected, reconnecting...', 'is-danger', event.reason);
+            }
         };
 
         ws.onerror = function(err) {
@@ -610,5 +1172,69 @@
         resizer.classList.remove('dragging');
     });
 
+    // Deep links: #file=<path>&L42 selects a file and scrolls to line 42;
+    // #file=<path>&L10-L15 marks a range of lines.
+    // Code files have linkable line numbers; clicking one updates the hash.
+    function parseHash() {
+        const params = new URLSearchParams(location.hash.slice(1));
+        let line = null;
+        for (const key of params.keys()) {
+            if (/^L\d+(-L?\d+)?$/.test(key)) line = key;
+        }
+        return { file: params.get('file'), line: line };
+    }
+
+    function fileFromHash() {
+        const hash = parseHash();
+        if (!hash.file) return null;
+        const file = files.find(f => f.path === hash.file) || files.find(f => f.name === hash.file);
+        if (!file || file.deleted) return null;
+        pendingLine = hash.line;
+        return file;
+    }
+
+    // scrollToPendingLine marks the pending line, or range like "L10-L15",
+    // and scrolls to its first line
+    function scrollToPendingLine() {
+        if (!pendingLine) return;
+        const match = pendingLine.match(/^L(\d+)(?:-L?(\d+))?$/);
+        pendingLine = null;
+        if (!match) return;
+        const first = parseInt(match[1], 10);
+        const last = match[2] ? parseInt(match[2], 10) : first;
+
+        content.querySelectorAll('.line-target').forEach(l => l.classList.remove('line-target'));
+        for (let n = first; n <= last; n++) {
+            const el = document.getElementById('L' + n);
+            if (el) el.classList.add('line-target');
+        }
+        const el = document.getElementById('L' + first);
+        if (!el) return;
+        // Unfold lines past --collapse-over
+        const folded = el.closest('details.code-collapse');
+        if (folded) folded.open = true;
+        el.scrollIntoView({ block: 'center' });
+    }
+
+    content.addEventListener('click', (e) => {
+        const link = e.target.closest('a[href^="#L"]');
+        if (!link || !activeFile) return;
+        e.preventDefault();
+        const line = link.getAttribute('href').slice(1);
+        history.replaceState(null, '', '#file=' + encodeURIComponent(activeFile) + '&' + line);
+        pendingLine = line;
+        scrollToPendingLine();
+    });
+
+    window.addEventListener('hashchange', () => {
+        const file = fileFromHash();
+        if (!file) return;
+        if (file.path !== activeFile) {
+            selectFile(file.path);
+        } else {
+            scrollToPendingLine();
+        }
+    });
+
     connect();
 })();
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Piped documents
+//
+// "livemd preview" reads content from stdin and sends it to the server
+// (PUT /api/stdin?name=NAME). The server keeps it in memory as an active
+// file at the synthetic path stdin:NAME, shown at the stable URL
+// /#file=stdin:NAME. Sending again under the same name replaces the
+// content and updates the browsers showing it. Nothing is written to disk
+// or restored after a restart.
+//
+// NAME picks the renderer by its extension, as a file name would; it
+// defaults to stdin.md.
+
+// stdinPrefix starts the path of every piped document.
+const stdinPrefix = "stdin:"
+
+// defaultStdinName is the name of a piped document sent without one.
+const defaultStdinName = "stdin.md"
+
+// isStdinPath reports whether path names a piped document rather than a
+// file on disk.
+func isStdinPath(path string) bool {
+	return strings.HasPrefix(path, stdinPrefix)
+}
+
+// stdinDoc is the content of a piped document.
+type stdinDoc struct {
+	content []byte
+	modTime time.Time
+}
+
+// SetStdin stores content as the piped document name and returns its path.
+// A new document is registered as an active file; an existing one is
+// re-rendered and sent to browsers.
+func (h *Hub) SetStdin(name string, content []byte) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid name %q: want a file name such as notes.md", name)
+	}
+	path := stdinPrefix + name
+
+	h.stdinMu.Lock()
+	h.stdin[path] = stdinDoc{content: content, modTime: time.Now()}
+	h.stdinMu.Unlock()
+
+	if _, ok := h.ResolvePath(path); !ok {
+		err := h.AddFileWithActive(path, true)
+		if err == nil {
+			return path, nil
+		}
+		if !errors.Is(err, errAlreadyRegistered) {
+			h.dropStdin(path)
+			return "", err
+		}
+		// Registered by a request racing this one; update it instead
+	}
+	if err := h.ActivateFile(path); err != nil {
+		return "", err
+	}
+	h.fileChanged(path)
+	return path, nil
+}
+
+// stdinContent returns the content of the piped document at path.
+func (h *Hub) stdinContent(path string) (stdinDoc, error) {
+	h.stdinMu.Lock()
+	defer h.stdinMu.Unlock()
+	doc, ok := h.stdin[path]
+	if !ok {
+		return stdinDoc{}, fmt.Errorf("no content piped as %s", strings.TrimPrefix(path, stdinPrefix))
+	}
+	return doc, nil
+}
+
+// dropStdin forgets the content of the piped document at path.
+func (h *Hub) dropStdin(path string) {
+	h.stdinMu.Lock()
+	defer h.stdinMu.Unlock()
+	delete(h.stdin, path)
+}
+
+// handleStdin stores the request body as a piped document (PUT ?name=) and
+// returns its path.
+func (s *Server) handleStdin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = defaultStdinName
+	}
+
+	content, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.postedContentLimit()))
+	if err != nil {
+		s.postedContentError(w, err)
+		return
+	}
+
+	path, err := s.hub.SetStdin(name, content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"path": path})
+}
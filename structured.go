@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdhtml "html"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Structured view
+//
+// With start --structured, data files are parsed and shown as a foldable
+// tree of their keys and values, with a toggle to the highlighted source.
+// A file that doesn't parse is shown as highlighted source with the error
+// above it and the offending line marked.
+//
+// JSON, YAML and TOML are parsed. Keys keep their order in the file.
+
+// structuredParsers maps extensions to the parser writing their tree.
+var structuredParsers = map[string]structuredParser{
+	".json": {name: "JSON", write: writeJSONTree},
+	".yaml": {name: "YAML", write: writeYAMLTree},
+	".yml":  {name: "YAML", write: writeYAMLTree},
+	".toml": {name: "TOML", write: writeTOMLTree},
+}
+
+// structuredParser writes a document as an HTML tree. On a parse error it
+// returns a structuredError when the position is known.
+type structuredParser struct {
+	name  string
+	write func(w *bytes.Buffer, content []byte) error
+}
+
+// structuredError is a parse error at a line.
+type structuredError struct {
+	line int
+	msg  string
+}
+
+func (e *structuredError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+// treeOpenDepth is how many levels of the tree start unfolded.
+const treeOpenDepth = 3
+
+// structuredView returns the parser for path when it is shown as a tree.
+func (r *Renderer) structuredView(path string) (structuredParser, bool) {
+	if !r.config.Structured {
+		return structuredParser{}, false
+	}
+	p, ok := structuredParsers[strings.ToLower(filepath.Ext(path))]
+	return p, ok
+}
+
+// renderStructured renders a data file as a tree next to its highlighted
+// source, or as the source with the parse error when it doesn't parse.
+// Files too large to highlight are too large for a tree and render as code.
+func (r *Renderer) renderStructured(path string, p structuredParser, content []byte) (string, error) {
+	if !r.highlights(string(content)) {
+		return r.renderCode(path, content)
+	}
+
+	var tree bytes.Buffer
+	if err := p.write(&tree, normalizeNewlines(content)); err != nil {
+		var hl [][2]int
+		var perr *structuredError
+		if errors.As(err, &perr) {
+			hl = [][2]int{{perr.line, perr.line}}
+		}
+		lines := strings.Split(string(normalizeNewlines(content)), "\n")
+		truncated := r.config.MaxLines > 0 && len(lines) > r.config.MaxLines
+		if truncated {
+			lines = lines[:r.config.MaxLines]
+		}
+
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, `<div class="diagram-error">%s error, %s</div>`, p.name, stdhtml.EscapeString(err.Error()))
+		if err := r.writeCode(&buf, path, strings.Join(lines, "\n"), truncated, 1, hl); err != nil {
+			return r.renderCode(path, content)
+		}
+		return buf.String(), nil
+	}
+
+	source, err := r.renderCode(path, content)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`<div class="structured" data-format="%s">
+<div class="preview-bar"><button class="button is-small preview-toggle">Show source</button></div>
+<div class="structured-tree preview-view">%s</div>
+<div class="preview-source is-hidden">%s</div>
+</div>`, strings.ToLower(p.name), tree.String(), source), nil
+}
+
+// writeJSONTree writes a JSON document as nested <details> elements. Keys
+// keep their order in the file.
+func writeJSONTree(w *bytes.Buffer, content []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := writeJSONValue(w, dec, "", 0); err != nil {
+		return jsonError(content, dec, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after the document")
+		}
+		return jsonError(content, dec, err)
+	}
+	return nil
+}
+
+// writeJSONValue writes the next value from dec, labeled with label (the
+// escaped key or index, empty at the top).
+func writeJSONValue(w *bytes.Buffer, dec *json.Decoder, label string, depth int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		writeTreeLeaf(w, label, jsonScalar(tok))
+		return nil
+	}
+
+	var children bytes.Buffer
+	n := 0
+	for dec.More() {
+		childLabel := indexLabel(n)
+		if delim == '{' {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			childLabel = keyLabel(fmt.Sprint(key))
+		}
+		if err := writeJSONValue(&children, dec, childLabel, depth+1); err != nil {
+			return err
+		}
+		n++
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	writeTreeNode(w, label, delim == '[', n, children.String(), depth)
+	return nil
+}
+
+// writeTreeNode writes a mapping (or a list) of n children as a foldable
+// node, or as its empty braces when it has none.
+func writeTreeNode(w *bytes.Buffer, label string, list bool, n int, children string, depth int) {
+	open, closing, unit := "{", "}", "key"
+	if list {
+		open, closing, unit = "[", "]", "item"
+	}
+	if n == 0 {
+		fmt.Fprintf(w, `<div class="tree-leaf">%s<span class="tree-brace">%s%s</span></div>`, label, open, closing)
+		return
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	openAttr := ""
+	if depth < treeOpenDepth {
+		openAttr = " open"
+	}
+	fmt.Fprintf(w, `<details class="tree-node"%s><summary>%s<span class="tree-brace">%s</span> <span class="tree-count">%d %s</span></summary><div class="tree-children">%s</div></details>`,
+		openAttr, label, open+"…"+closing, n, unit, children)
+}
+
+// writeTreeLeaf writes a scalar, already rendered as HTML.
+func writeTreeLeaf(w *bytes.Buffer, label, value string) {
+	fmt.Fprintf(w, `<div class="tree-leaf">%s%s</div>`, label, value)
+}
+
+// keyLabel labels a child of a mapping.
+func keyLabel(key string) string {
+	return fmt.Sprintf(`<span class="tree-key">%s</span>: `, stdhtml.EscapeString(key))
+}
+
+// indexLabel labels an item of a list.
+func indexLabel(i int) string {
+	return fmt.Sprintf(`<span class="tree-index">%d</span> `, i)
+}
+
+// treeString renders a string value, quoted.
+func treeString(s string) string {
+	return fmt.Sprintf(`<span class="tree-string">%s</span>`, stdhtml.EscapeString(strconv.Quote(s)))
+}
+
+// jsonScalar renders a JSON string, number, boolean or null.
+func jsonScalar(tok json.Token) string {
+	switch v := tok.(type) {
+	case string:
+		return treeString(v)
+	case json.Number:
+		return fmt.Sprintf(`<span class="tree-number">%s</span>`, v)
+	case bool:
+		return fmt.Sprintf(`<span class="tree-bool">%t</span>`, v)
+	default:
+		return `<span class="tree-null">null</span>`
+	}
+}
+
+// jsonError turns a decoding error into a structuredError at the line
+// where decoding stopped.
+func jsonError(content []byte, dec *json.Decoder, err error) error {
+	offset := dec.InputOffset()
+	var serr *json.SyntaxError
+	if errors.As(err, &serr) {
+		offset = serr.Offset
+	}
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	msg := err.Error()
+	if errors.Is(err, io.ErrUnexpectedEOF) || err == io.EOF {
+		msg = "unexpected end of the document"
+	}
+	return &structuredError{line: bytes.Count(content[:offset], []byte("\n")) + 1, msg: msg}
+}
+
+// writeYAMLTree writes a YAML document as a tree. A stream of several
+// documents is shown as a list of them.
+func writeYAMLTree(w *bytes.Buffer, content []byte) error {
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	var docs []*yaml.Node
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return yamlError(err)
+		}
+		docs = append(docs, &doc)
+	}
+
+	switch len(docs) {
+	case 0:
+		writeTreeLeaf(w, "", `<span class="tree-null">null</span>`)
+	case 1:
+		writeYAMLNode(w, docs[0], "", 0)
+	default:
+		var children bytes.Buffer
+		for i, doc := range docs {
+			writeYAMLNode(&children, doc, indexLabel(i), 1)
+		}
+		writeTreeNode(w, "", true, len(docs), children.String(), 0)
+	}
+	return nil
+}
+
+// writeYAMLNode writes a node of a YAML document, labeled with label.
+func writeYAMLNode(w *bytes.Buffer, n *yaml.Node, label string, depth int) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			writeTreeLeaf(w, label, `<span class="tree-null">null</span>`)
+			return
+		}
+		writeYAMLNode(w, n.Content[0], label, depth)
+	case yaml.MappingNode:
+		var children bytes.Buffer
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			writeYAMLNode(&children, n.Content[i+1], keyLabel(n.Content[i].Value), depth+1)
+		}
+		writeTreeNode(w, label, false, len(n.Content)/2, children.String(), depth)
+	case yaml.SequenceNode:
+		var children bytes.Buffer
+		for i, item := range n.Content {
+			writeYAMLNode(&children, item, indexLabel(i), depth+1)
+		}
+		writeTreeNode(w, label, true, len(n.Content), children.String(), depth)
+	case yaml.AliasNode:
+		// Shown as written; the anchored value is shown where it's defined
+		writeTreeLeaf(w, label, fmt.Sprintf(`<span class="tree-alias">*%s</span>`, stdhtml.EscapeString(n.Value)))
+	default:
+		writeTreeLeaf(w, label, yamlScalar(n))
+	}
+}
+
+// yamlScalar renders a YAML scalar by its resolved tag.
+func yamlScalar(n *yaml.Node) string {
+	switch n.ShortTag() {
+	case "!!null":
+		return `<span class="tree-null">null</span>`
+	case "!!bool":
+		return fmt.Sprintf(`<span class="tree-bool">%s</span>`, stdhtml.EscapeString(n.Value))
+	case "!!int", "!!float":
+		return fmt.Sprintf(`<span class="tree-number">%s</span>`, stdhtml.EscapeString(n.Value))
+	default:
+		return treeString(n.Value)
+	}
+}
+
+// yamlErrorLine matches the position yaml.v3 puts in its syntax errors.
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// yamlError turns a YAML syntax error into a structuredError when it
+// names a line.
+func yamlError(err error) error {
+	m := yamlErrorLine.FindStringSubmatch(err.Error())
+	if m == nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	line, _ := strconv.Atoi(m[1])
+	return &structuredError{line: line, msg: m[2]}
+}
+
+// tomlErrorPrefix matches the position heading a TOML parse error, as in
+// `toml: line 3 (last key "a"): `.
+var tomlErrorPrefix = regexp.MustCompile(`^toml: line \d+( \(last key .*?\))?: `)
+
+// writeTOMLTree writes a TOML document as a tree. The decoder returns
+// tables as maps, so their keys are put back in the order the metadata
+// lists them, which is their order in the file.
+func writeTOMLTree(w *bytes.Buffer, content []byte) error {
+	var doc map[string]interface{}
+	md, err := toml.Decode(string(content), &doc)
+	if err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			msg := tomlErrorPrefix.ReplaceAllString(err.Error(), "")
+			return &structuredError{line: perr.Position.Line, msg: msg}
+		}
+		return err
+	}
+
+	order := make(map[string]int)
+	for i, key := range md.Keys() {
+		path := strings.Join(key, "\x00")
+		if _, seen := order[path]; !seen {
+			order[path] = i
+		}
+	}
+	writeTOMLValue(w, doc, nil, order, "", 0)
+	return nil
+}
+
+// writeTOMLValue writes a decoded TOML value found at the key path path
+// (without array indexes), labeled with label.
+func writeTOMLValue(w *bytes.Buffer, v interface{}, path []string, order map[string]int, label string, depth int) {
+	var children bytes.Buffer
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		position := func(k string) int {
+			if i, ok := order[strings.Join(append(path[:len(path):len(path)], k), "\x00")]; ok {
+				return i
+			}
+			return len(order)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			pi, pj := position(keys[i]), position(keys[j])
+			if pi != pj {
+				return pi < pj
+			}
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys {
+			writeTOMLValue(&children, v[k], append(path[:len(path):len(path)], k), order, keyLabel(k), depth+1)
+		}
+		writeTreeNode(w, label, false, len(keys), children.String(), depth)
+	case []map[string]interface{}:
+		// An array of tables
+		for i, table := range v {
+			writeTOMLValue(&children, table, path, order, indexLabel(i), depth+1)
+		}
+		writeTreeNode(w, label, true, len(v), children.String(), depth)
+	case []interface{}:
+		for i, item := range v {
+			writeTOMLValue(&children, item, path, order, indexLabel(i), depth+1)
+		}
+		writeTreeNode(w, label, true, len(v), children.String(), depth)
+	default:
+		writeTreeLeaf(w, label, tomlScalar(v))
+	}
+}
+
+// tomlScalar renders a TOML string, number, boolean or date.
+func tomlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return treeString(v)
+	case int64:
+		return fmt.Sprintf(`<span class="tree-number">%d</span>`, v)
+	case float64:
+		return fmt.Sprintf(`<span class="tree-number">%s</span>`, strconv.FormatFloat(v, 'g', -1, 64))
+	case bool:
+		return fmt.Sprintf(`<span class="tree-bool">%t</span>`, v)
+	case time.Time:
+		// The decoder marks local dates and times, which have no offset
+		// to show, by the name of their location
+		layout := time.RFC3339Nano
+		switch v.Location().String() {
+		case "date-local":
+			layout = "2006-01-02"
+		case "time-local":
+			layout = "15:04:05.999999999"
+		case "datetime-local":
+			layout = "2006-01-02T15:04:05.999999999"
+		}
+		return fmt.Sprintf(`<span class="tree-number">%s</span>`, v.Format(layout))
+	default:
+		return treeString(fmt.Sprint(v))
+	}
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -38,26 +40,77 @@ type githubAsset struct {
 }
 
 // cmdUpdate checks GitHub for a newer release and self-updates the binary.
+// Given a version ("livemd update v1.2.3") it installs that release instead.
+//
+// Flags:
+//   - -y, --yes: Don't ask before crossing a major version
+//   - --force: Allow installing an older release, or reinstalling this one
 func cmdUpdate() {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	var yes bool
+	fs.BoolVar(&yes, "yes", false, "don't ask before updating to another major version")
+	fs.BoolVar(&yes, "y", false, "don't ask before updating to another major version")
+	force := fs.Bool("force", false, "allow downgrading or reinstalling the current version")
+	fs.Parse(reorderArgs(os.Args[2:]))
+	pinned := fs.Arg(0)
+
 	if Version == "dev" {
 		fmt.Fprintln(os.Stderr, "Cannot update a dev build. Install a release version first.")
 		os.Exit(1)
 	}
 
-	fmt.Println("Checking for updates...")
-
-	release, err := fetchLatestRelease()
+	var release *githubRelease
+	var err error
+	if pinned != "" {
+		if !strings.HasPrefix(pinned, "v") {
+			pinned = "v" + pinned
+		}
+		fmt.Printf("Looking up %s...\n", pinned)
+		release, err = fetchRelease(pinned)
+	} else {
+		fmt.Println("Checking for updates...")
+		release, err = fetchLatestRelease()
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error checking for updates: %v\n", err)
 		os.Exit(1)
 	}
 
-	if !isNewer(Version, release.TagName) {
+	cmp := compareSemver(strings.TrimPrefix(release.TagName, "v"), strings.TrimPrefix(Version, "v"))
+	switch {
+	case cmp == 0 && !*force:
+		fmt.Printf("Already up to date (%s)\n", Version)
+		return
+	case cmp < 0 && pinned == "":
+		// The latest release is older than a locally built tag; nothing to do
 		fmt.Printf("Already up to date (%s)\n", Version)
 		return
+	case cmp < 0 && !*force:
+		fmt.Fprintf(os.Stderr, "%s is older than the installed %s. Use --force to downgrade.\n", release.TagName, Version)
+		os.Exit(1)
+	case cmp < 0:
+		fmt.Printf("Downgrading to %s (current: %s)\n", release.TagName, Version)
+	case cmp == 0:
+		fmt.Printf("Reinstalling %s\n", release.TagName)
+	default:
+		fmt.Printf("New version available: %s (current: %s)\n", release.TagName, Version)
 	}
 
-	fmt.Printf("New version available: %s (current: %s)\n", release.TagName, Version)
+	// A new major version may change flags, config or behavior
+	if majorVersion(release.TagName) != majorVersion(Version) && !yes {
+		fmt.Printf("%s is a different major version than %s and may include breaking changes.\n", release.TagName, Version)
+		if !isTerminal(os.Stdin) {
+			fmt.Fprintln(os.Stderr, "Cancelled: no terminal to confirm. Use --yes.")
+			os.Exit(1)
+		}
+		fmt.Print("Continue? [y/N] ")
+		var response string
+		fmt.Scanln(&response)
+		if strings.ToLower(response) != "y" {
+			fmt.Println("Cancelled.")
+			return
+		}
+	}
 
 	assetName := fmt.Sprintf("livemd-%s-%s", runtime.GOOS, runtime.GOARCH)
 	if runtime.GOOS == "windows" {
@@ -79,7 +132,12 @@ func cmdUpdate() {
 
 	fmt.Printf("Downloading %s...\n", assetName)
 
-	binary, err := downloadAsset(downloadURL)
+	// Progress redraws one line, which only makes sense on a terminal
+	var progress io.Writer
+	if isTerminal(os.Stdout) {
+		progress = os.Stdout
+	}
+	binary, err := downloadAsset(downloadURL, progress)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error downloading update: %v\n", err)
 		os.Exit(1)
@@ -90,19 +148,46 @@ func cmdUpdate() {
 		os.Exit(1)
 	}
 
-	fmt.Printf("Updated to %s\n", release.TagName)
+	if cmp > 0 {
+		fmt.Printf("Updated to %s\n", release.TagName)
+	} else {
+		fmt.Printf("Installed %s\n", release.TagName)
+	}
 }
 
 func fetchLatestRelease() (*githubRelease, error) {
 	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", githubRepo)
-	resp, err := http.Get(url)
+	resp, err := githubGet(url)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned %d", resp.StatusCode)
+		return nil, githubError(resp, "GitHub API")
+	}
+
+	var release githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// fetchRelease returns the release tagged tag.
+func fetchRelease(tag string) (*githubRelease, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", githubRepo, tag)
+	resp, err := githubGet(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("no release %s", tag)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, githubError(resp, "GitHub API")
 	}
 
 	var release githubRelease
@@ -112,6 +197,13 @@ func fetchLatestRelease() (*githubRelease, error) {
 	return &release, nil
 }
 
+// majorVersion returns the major number of a version like "v1.2.3".
+func majorVersion(v string) int {
+	var major int
+	fmt.Sscanf(strings.TrimPrefix(v, "v"), "%d", &major)
+	return major
+}
+
 // isNewer returns true if remote version is newer than local.
 // Both are expected to be semver tags like "v1.2.3".
 func isNewer(local, remote string) bool {
@@ -141,18 +233,114 @@ func compareSemver(a, b string) int {
 	return 0
 }
 
-func downloadAsset(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+// githubClient has no overall timeout, as release downloads may be slow,
+// but gives up on a proxy or server that doesn't answer.
+var githubClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// githubGet requests url from GitHub. Proxies are taken from HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY. When GITHUB_TOKEN is set it is sent for
+// authentication, which raises the API rate limit from 60 requests an
+// hour; redirects to other hosts (release downloads) don't get it.
+func githubGet(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "livemd/"+Version)
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return githubClient.Do(req)
+}
+
+// githubError describes a failed GitHub response from what, naming the
+// rate limit and a rejected GITHUB_TOKEN rather than just the status.
+func githubError(resp *http.Response, what string) error {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized && os.Getenv("GITHUB_TOKEN") != "":
+		return fmt.Errorf("%s rejected GITHUB_TOKEN (401); check that the token is valid", what)
+	case (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0":
+		msg := fmt.Sprintf("%s rate limit exceeded", what)
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			msg += fmt.Sprintf(" until %s", time.Unix(reset, 0).Format("15:04"))
+		}
+		if os.Getenv("GITHUB_TOKEN") == "" {
+			msg += "; set GITHUB_TOKEN to raise the limit"
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%s returned %d", what, resp.StatusCode)
+}
+
+// downloadAsset downloads a release binary. If progress is not nil, the
+// download's progress is drawn on it as a single line that is redrawn as
+// bytes arrive.
+func downloadAsset(url string, progress io.Writer) ([]byte, error) {
+	resp, err := githubGet(url)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("download returned %d", resp.StatusCode)
+		return nil, githubError(resp, "download")
+	}
+
+	if progress == nil {
+		return io.ReadAll(resp.Body)
+	}
+	pr := &progressReader{r: resp.Body, w: progress, total: resp.ContentLength}
+	data, err := io.ReadAll(pr)
+	pr.finish()
+	return data, err
+}
+
+// progressReader draws how much of a download has been read: a bar and
+// percentage when the size is known, the bytes read so far otherwise.
+type progressReader struct {
+	r     io.Reader
+	w     io.Writer
+	total int64 // -1 when unknown
+	read  int64
+	drawn time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	// Redrawing on every read would flood slow terminals
+	if time.Since(p.drawn) >= 100*time.Millisecond {
+		p.draw()
 	}
+	return n, err
+}
+
+func (p *progressReader) draw() {
+	p.drawn = time.Now()
+	if p.total <= 0 {
+		fmt.Fprintf(p.w, "\r  %s  ", formatSize(p.read))
+		return
+	}
+	const width = 30
+	done := int(p.read * width / p.total)
+	if done > width {
+		done = width
+	}
+	fmt.Fprintf(p.w, "\r  [%s%s] %3d%% %s / %s  ", strings.Repeat("=", done), strings.Repeat(" ", width-done),
+		p.read*100/p.total, formatSize(p.read), formatSize(p.total))
+}
 
-	return io.ReadAll(resp.Body)
+// finish draws the final state and ends the line.
+func (p *progressReader) finish() {
+	p.draw()
+	fmt.Fprintln(p.w)
 }
 
 // replaceBinary replaces the currently running binary with new content.
@@ -203,14 +391,14 @@ func replaceUnix(execPath string, newBinary []byte) error {
 // fetchAllReleases returns all GitHub releases (for changelog display).
 func fetchAllReleases() ([]githubRelease, error) {
 	url := fmt.Sprintf("https://api.github.com/repos/%s/releases", githubRepo)
-	resp, err := http.Get(url)
+	resp, err := githubGet(url)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned %d", resp.StatusCode)
+		return nil, githubError(resp, "GitHub API")
 	}
 
 	var releases []githubRelease
@@ -1,28 +1,72 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
+// FileWatcher watches one file, or polls one URL, and reports changes and
+// deletion through callbacks. Watcher is the fsnotify implementation; the
+// Hub creates them through ServerConfig.NewWatcher, so tests can hand it a
+// fake that fires the callbacks without touching the disk.
+type FileWatcher interface {
+	Watch(path string, onChange, onDelete func()) error
+	// WatchURL passes onChange the content and modification time it
+	// fetched, so the change needn't be fetched again
+	WatchURL(url string, interval time.Duration, onChange func([]byte, time.Time), onDelete func()) error
+	WatchShared(shared *SharedWatcher, path string, onChange, onDelete func()) error
+	// OnStall sets the callback for a watcher that stops delivering events
+	// without being closed. It is set before watching starts.
+	OnStall(fn func(error))
+	Close() error
+}
+
 // Watcher watches a file for changes with debouncing
 type Watcher struct {
 	watcher *fsnotify.Watcher
 	done    chan struct{}
 	mu      sync.Mutex
 	timer   *time.Timer
+
+	// Set when the file is watched through a SharedWatcher
+	shared   *SharedWatcher
+	path     string
+	onChange func()
+	onDelete func()
+
+	// onStall, if set, is called once when the watcher stops delivering
+	// events for a reason other than Close, e.g. an fsnotify error
+	onStall   func(error)
+	stallOnce sync.Once
+
+	// maxRemoteSize is the most WatchURL reads of a URL's body (start
+	// --max-file-size); 0 for no limit
+	maxRemoteSize int64
 }
 
+// errWatcherClosed is the stall reason when fsnotify closes its channels
+// without the watcher being closed.
+var errWatcherClosed = errors.New("watcher stopped unexpectedly")
+
 func NewWatcher() *Watcher {
 	return &Watcher{
 		done: make(chan struct{}),
 	}
 }
 
+// OnStall sets the callback called once when the watcher stalls.
+func (w *Watcher) OnStall(fn func(error)) {
+	w.onStall = fn
+}
+
 func (w *Watcher) Watch(filepath string, onChange func(), onDelete func()) error {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
@@ -40,11 +84,13 @@ func (w *Watcher) Watch(filepath string, onChange func(), onDelete func()) error
 			select {
 			case event, ok := <-watcher.Events:
 				if !ok {
+					w.stall(errWatcherClosed)
 					return
 				}
 
-				// Only react to write events
-				if event.Op&fsnotify.Write == fsnotify.Write {
+				// React to writes, and to permission changes that make the
+				// file unreadable or readable again
+				if event.Op&(fsnotify.Write|fsnotify.Chmod) != 0 {
 					w.debounce(onChange)
 				}
 
@@ -59,16 +105,71 @@ func (w *Watcher) Watch(filepath string, onChange func(), onDelete func()) error
 						}
 					} else {
 						// File was recreated (editor behavior)
-						watcher.Add(filepath)
+						if err := watcher.Add(filepath); err != nil {
+							w.stall(fmt.Errorf("watching the recreated file: %w", err))
+							return
+						}
 						w.debounce(onChange)
 					}
 				}
 
 			case err, ok := <-watcher.Errors:
 				if !ok {
+					w.stall(errWatcherClosed)
 					return
 				}
 				log.Printf("Watcher error: %v", err)
+				// Events may have been lost, so the file can't be trusted
+				// to be current any more
+				w.stall(err)
+				return
+
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// WatchURL polls a remote URL for changes, since fsnotify can only watch
+// local files. onChange is called with the fetched content when it differs
+// from the last fetch, or with nil content when the body grew over
+// maxRemoteSize; onDelete is called once when the URL stops answering with
+// 200 and onChange again when it comes back.
+func (w *Watcher) WatchURL(url string, interval time.Duration, onChange func([]byte, time.Time), onDelete func()) error {
+	last, _, err := w.fetch(url)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		available := true
+		for {
+			select {
+			case <-ticker.C:
+				content, modTime, err := w.fetch(url)
+				if err != nil {
+					if _, ok := err.(*remoteStatusError); ok && available {
+						available = false
+						if onDelete != nil {
+							onDelete()
+						}
+					} else if !ok {
+						log.Printf("Watcher error: %v", err)
+					}
+					continue
+				}
+
+				if !available || (content == nil) != (last == nil) || !bytes.Equal(content, last) {
+					available = true
+					last = content
+					onChange(content, modTime)
+				}
 
 			case <-w.done:
 				return
@@ -79,6 +180,30 @@ func (w *Watcher) Watch(filepath string, onChange func(), onDelete func()) error
 	return nil
 }
 
+// fetch fetches a watched URL for WatchURL. A body over maxRemoteSize
+// comes back as nil content, and any other body as non-nil content.
+func (w *Watcher) fetch(url string) ([]byte, time.Time, error) {
+	content, modTime, err := fetchRemote(url, w.maxRemoteSize)
+	var tooLarge *remoteTooLargeError
+	if errors.As(err, &tooLarge) {
+		return nil, modTime, nil
+	}
+	if err == nil && content == nil {
+		content = []byte{}
+	}
+	return content, modTime, err
+}
+
+// WatchShared watches a file through shared instead of its own fsnotify
+// watcher. The callbacks behave as with Watch.
+func (w *Watcher) WatchShared(shared *SharedWatcher, path string, onChange func(), onDelete func()) error {
+	w.shared = shared
+	w.path = path
+	w.onChange = onChange
+	w.onDelete = onDelete
+	return shared.add(path, w)
+}
+
 func (w *Watcher) debounce(fn func()) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -90,10 +215,164 @@ func (w *Watcher) debounce(fn func()) {
 	w.timer = time.AfterFunc(100*time.Millisecond, fn)
 }
 
+// stall reports that the watcher stopped delivering events, unless it was
+// closed on purpose.
+func (w *Watcher) stall(err error) {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+	w.stallOnce.Do(func() {
+		if w.onStall != nil {
+			w.onStall(err)
+		}
+	})
+}
+
 func (w *Watcher) Close() error {
 	close(w.done)
+	if w.shared != nil {
+		w.shared.remove(w.path)
+	}
 	if w.watcher != nil {
 		return w.watcher.Close()
 	}
 	return nil
 }
+
+// SharedWatcher watches many files through one fsnotify watcher on their
+// directories, instead of one fsnotify watcher per file. With hundreds of
+// files the per-file watchers run into the open file and inotify instance
+// limits; used with start --single-watcher.
+type SharedWatcher struct {
+	watcher *fsnotify.Watcher
+	mu      sync.Mutex
+	// Both maps are keyed by NormalizePathForComparison, so an event is
+	// matched to its file whatever the case of the path it reports (the
+	// drive letter on Windows may differ from the registered path)
+	files  map[string]*Watcher // watched file -> its Watcher
+	dirs   map[string]int      // watched directory -> files watched in it
+	closed bool
+}
+
+func NewSharedWatcher() (*SharedWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	s := &SharedWatcher{
+		watcher: watcher,
+		files:   make(map[string]*Watcher),
+		dirs:    make(map[string]int),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *SharedWatcher) add(path string, w *Watcher) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := NormalizePathForComparison(path)
+	dir := filepath.Dir(key)
+	if s.dirs[dir] == 0 {
+		if err := s.watcher.Add(filepath.Dir(path)); err != nil {
+			return err
+		}
+	}
+	s.dirs[dir]++
+	s.files[key] = w
+	return nil
+}
+
+func (s *SharedWatcher) remove(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := NormalizePathForComparison(path)
+	if _, ok := s.files[key]; !ok {
+		return
+	}
+	delete(s.files, key)
+	dir := filepath.Dir(key)
+	s.dirs[dir]--
+	if s.dirs[dir] == 0 {
+		delete(s.dirs, dir)
+		s.watcher.Remove(filepath.Dir(path))
+	}
+}
+
+// run dispatches directory events to the Watcher of the file they name.
+// Events for other files in a watched directory are ignored.
+func (s *SharedWatcher) run() {
+	for {
+		select {
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				s.stallAll(errWatcherClosed)
+				return
+			}
+			s.mu.Lock()
+			w := s.files[NormalizePathForComparison(event.Name)]
+			s.mu.Unlock()
+			if w == nil {
+				continue
+			}
+
+			// Editors that save atomically create the file anew; a chmod
+			// may make the file unreadable or readable again
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Chmod) != 0 {
+				w.debounce(w.onChange)
+			}
+
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				// Wait briefly for editors that delete+recreate
+				w.debounce(func() {
+					if _, err := os.Stat(w.path); os.IsNotExist(err) {
+						if w.onDelete != nil {
+							w.onDelete()
+						}
+					} else {
+						w.onChange()
+					}
+				})
+			}
+
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				s.stallAll(errWatcherClosed)
+				return
+			}
+			log.Printf("Watcher error: %v", err)
+			// Events for any of the files may have been lost
+			s.stallAll(err)
+		}
+	}
+}
+
+// stallAll reports every watched file as stalled, unless the shared watcher
+// was closed on purpose.
+func (s *SharedWatcher) stallAll(err error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	watchers := make([]*Watcher, 0, len(s.files))
+	for _, w := range s.files {
+		watchers = append(watchers, w)
+	}
+	s.mu.Unlock()
+
+	for _, w := range watchers {
+		w.stall(err)
+	}
+}
+
+func (s *SharedWatcher) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return s.watcher.Close()
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWatcher is a FileWatcher whose callbacks the test fires itself.
+type fakeWatcher struct {
+	mu       sync.Mutex
+	path     string
+	onChange func()
+	onDelete func()
+	onStall  func(error)
+	closed   bool
+
+	onRemoteChange func([]byte, time.Time) // set by WatchURL
+}
+
+func (w *fakeWatcher) Watch(path string, onChange, onDelete func()) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.path, w.onChange, w.onDelete = path, onChange, onDelete
+	return nil
+}
+
+func (w *fakeWatcher) WatchURL(url string, interval time.Duration, onChange func([]byte, time.Time), onDelete func()) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.path, w.onRemoteChange, w.onDelete = url, onChange, onDelete
+	return nil
+}
+
+func (w *fakeWatcher) WatchShared(shared *SharedWatcher, path string, onChange, onDelete func()) error {
+	return w.Watch(path, onChange, onDelete)
+}
+
+func (w *fakeWatcher) OnStall(fn func(error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onStall = fn
+}
+
+func (w *fakeWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+func (w *fakeWatcher) isClosed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closed
+}
+
+// fakeWatchers hands out fake watchers to a hub and finds them by path.
+type fakeWatchers struct {
+	mu       sync.Mutex
+	watchers []*fakeWatcher
+}
+
+func (f *fakeWatchers) newWatcher() FileWatcher {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWatcher{}
+	f.watchers = append(f.watchers, w)
+	return w
+}
+
+// latest returns the last watcher started for path, failing the test if
+// there is none.
+func (f *fakeWatchers) latest(t *testing.T, path string) *fakeWatcher {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.watchers) - 1; i >= 0; i-- {
+		w := f.watchers[i]
+		w.mu.Lock()
+		watched := w.path
+		w.mu.Unlock()
+		if watched == path {
+			return w
+		}
+	}
+	t.Fatalf("no watcher for %s", path)
+	return nil
+}
+
+// count returns how many watchers were started for path.
+func (f *fakeWatchers) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, w := range f.watchers {
+		w.mu.Lock()
+		if w.path == path {
+			n++
+		}
+		w.mu.Unlock()
+	}
+	return n
+}
+
+// newFakeWatcherHub starts a hub whose watchers are fakes, with one active
+// markdown file, and returns the hub, its watchers and the file's path.
+func newFakeWatcherHub(t *testing.T) (*Hub, *fakeWatchers, string) {
+	t.Helper()
+	watchers := &fakeWatchers{}
+	h := newTestHub(t, ServerConfig{NewWatcher: watchers.newWatcher})
+	path := NormalizePath(writeTestFile(t, t.TempDir(), "notes.md", "# First\n"))
+	if err := h.AddFileWithActive(path, true); err != nil {
+		t.Fatal(err)
+	}
+	return h, watchers, path
+}
+
+// fileState returns a copy of the file registered under path.
+func fileState(t *testing.T, h *Hub, path string) WatchedFile {
+	t.Helper()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	f, ok := h.files[path]
+	if !ok {
+		t.Fatalf("%s is not registered", path)
+	}
+	return *f
+}
+
+func TestFileChangedRerenders(t *testing.T) {
+	h, watchers, path := newFakeWatcherHub(t)
+	w := watchers.latest(t, path)
+	before := fileState(t, h, path)
+
+	if err := os.WriteFile(path, []byte("# Second\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.onChange()
+
+	after := fileState(t, h, path)
+	if !strings.Contains(after.HTML, "Second") {
+		t.Errorf("HTML after change = %q, want the new heading", after.HTML)
+	}
+	if after.Revision != before.Revision+1 {
+		t.Errorf("Revision = %d, want %d", after.Revision, before.Revision+1)
+	}
+	if after.Status != statusReady {
+		t.Errorf("Status = %q, want %q", after.Status, statusReady)
+	}
+
+	// Saved without changes: nothing is re-rendered
+	w.onChange()
+	if again := fileState(t, h, path); again.Revision != after.Revision {
+		t.Errorf("Revision after an unchanged save = %d, want %d", again.Revision, after.Revision)
+	}
+}
+
+func TestRemoveDeletedFiles(t *testing.T) {
+	h, watchers, path := newFakeWatcherHub(t)
+	other := NormalizePath(writeTestFile(t, t.TempDir(), "other.md", "# Other\n"))
+	if err := h.AddFileWithActive(other, true); err != nil {
+		t.Fatal(err)
+	}
+	w := watchers.latest(t, path)
+
+	w.onDelete()
+	if f := fileState(t, h, path); !f.Deleted || f.Active {
+		t.Fatalf("after delete: Deleted %v, Active %v; want true, false", f.Deleted, f.Active)
+	}
+
+	if n := h.RemoveDeletedFiles(); n != 1 {
+		t.Errorf("RemoveDeletedFiles = %d, want 1", n)
+	}
+	h.mu.RLock()
+	_, stillThere := h.files[path]
+	_, otherThere := h.files[other]
+	h.mu.RUnlock()
+	if stillThere || !otherThere {
+		t.Errorf("after RemoveDeletedFiles: deleted file registered %v, other file registered %v", stillThere, otherThere)
+	}
+	if !w.isClosed() {
+		t.Error("the deleted file's watcher wasn't closed")
+	}
+}
+
+func TestReactivateFile(t *testing.T) {
+	h, watchers, path := newFakeWatcherHub(t)
+	first := watchers.latest(t, path)
+
+	if err := h.DeactivateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !first.isClosed() {
+		t.Error("DeactivateFile didn't close the watcher")
+	}
+
+	// Changed while nobody watched it
+	if err := os.WriteFile(path, []byte("# Changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.ActivateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	f := fileState(t, h, path)
+	if !f.Active || !strings.Contains(f.HTML, "Changed") {
+		t.Errorf("after ActivateFile: Active %v, HTML %q; want active with the new content", f.Active, f.HTML)
+	}
+	if n := watchers.count(path); n != 2 {
+		t.Errorf("watchers started = %d, want 2", n)
+	}
+
+	// A stalled watcher leaves the file inactive until activated again
+	second := watchers.latest(t, path)
+	second.onStall(os.ErrClosed)
+	if f := fileState(t, h, path); f.Active || !f.Stalled {
+		t.Fatalf("after stall: Active %v, Stalled %v; want false, true", f.Active, f.Stalled)
+	}
+	if err := h.ActivateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if f := fileState(t, h, path); !f.Active || f.Stalled {
+		t.Errorf("after reactivating: Active %v, Stalled %v; want true, false", f.Active, f.Stalled)
+	}
+	if n := watchers.count(path); n != 3 {
+		t.Errorf("watchers started = %d, want 3", n)
+	}
+}
+
+func TestConcurrentChangesKeepTheLatest(t *testing.T) {
+	h, _, path := newFakeWatcherHub(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				h.fileChanged(path)
+				if i == 0 {
+					os.WriteFile(path, []byte(fmt.Sprintf("# Version %d\n", j)), 0644)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := os.WriteFile(path, []byte("# Final\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.fileChanged(path)
+	if f := fileState(t, h, path); !strings.Contains(f.HTML, "Final") {
+		t.Errorf("HTML after the last change = %q, want the final heading", f.HTML)
+	}
+	h.mu.RLock()
+	reloading := len(h.reloads)
+	h.mu.RUnlock()
+	if reloading != 0 {
+		t.Errorf("%d reloads still registered, want none", reloading)
+	}
+}
+
+func TestActivateWhileAdding(t *testing.T) {
+	for _, fails := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fails=%v", fails), func(t *testing.T) {
+			// The first render of a URL waits until the test releases it
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+				if fails {
+					http.Error(w, "gone", http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprint(w, "# Remote\n")
+			}))
+			defer srv.Close()
+			watchers := &fakeWatchers{}
+			h := newTestHub(t, ServerConfig{NewWatcher: watchers.newWatcher})
+			url := srv.URL + "/notes.md"
+
+			added := make(chan error, 1)
+			go func() { added <- h.AddFile(url) }()
+			for {
+				h.mu.RLock()
+				_, pending := h.files[url]
+				h.mu.RUnlock()
+				if pending {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			// Activation doesn't wait for the first render
+			activated := make(chan error, 1)
+			go func() { activated <- h.ActivateFile(url) }()
+			select {
+			case err := <-activated:
+				if err != nil {
+					t.Fatalf("ActivateFile while adding: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				close(release)
+				t.Fatal("ActivateFile waited for the file's first render")
+			}
+			close(release)
+			err := <-added
+
+			if fails {
+				if err == nil {
+					t.Fatal("AddFile succeeded, want the fetch error")
+				}
+				h.mu.RLock()
+				_, registered := h.files[url]
+				watching := len(h.watchers)
+				h.mu.RUnlock()
+				if registered || watching != 0 || watchers.count(url) != 0 {
+					t.Errorf("after a failed add: registered %v, %d watchers; want neither", registered, watching)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := fileState(t, h, url)
+			if !f.Active || f.Status != statusReady || !strings.Contains(f.HTML, "Remote") {
+				t.Errorf("after adding: Active %v, Status %q, HTML %q; want active and rendered", f.Active, f.Status, f.HTML)
+			}
+			if n := watchers.count(url); n != 1 {
+				t.Errorf("watchers started = %d, want 1", n)
+			}
+		})
+	}
+}